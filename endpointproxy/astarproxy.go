@@ -17,11 +17,11 @@ type AstarProxy struct {
 }
 
 // NewProxy takes target host and creates a reverse proxy
-func (h *AstarProxy) startAstarProxy(targetHost string, port int, chainId uint64) error {
+func (h *AstarProxy) newAstarProxy(targetHost string) (*httputil.ReverseProxy, error) {
 	var err error
 	h.astarTargetUrl, err = url.Parse(targetHost)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	p := httputil.NewSingleHostReverseProxy(h.astarTargetUrl)
 	originalDirector := p.Director
@@ -29,10 +29,7 @@ func (h *AstarProxy) startAstarProxy(targetHost string, port int, chainId uint64
 		originalDirector(req)
 		h.modifyAstarRequest(req)
 	}
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", proxyRequestHandler(p))
-	go startCustomProxyByPort(port, mux, chainId, targetHost)
-	return nil
+	return p, nil
 }
 
 func (h *AstarProxy) modifyAstarRequest(req *http.Request) {
@@ -47,6 +44,8 @@ func (h *AstarProxy) modifyAstarRequest(req *http.Request) {
 	msg := &jsonrpcMessage{}
 	if err = json.Unmarshal(reqStr, msg); err != nil {
 		log.Errorf("fail to unmarshal this astar req body err:%s", err.Error())
+		// e.g. batch request, forward it as it is
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqStr))
 		return
 	}
 	if msg.Method == MethodEthGetCode {
@@ -0,0 +1,200 @@
+package endpointproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celer-network/goutils/log"
+)
+
+const (
+	HeaderHmacKey       = "X-Proxy-Key"
+	HeaderHmacTimestamp = "X-Proxy-Timestamp"
+	HeaderHmacSignature = "X-Proxy-Signature"
+
+	hmacMaxClockSkew = 5 * time.Minute
+)
+
+var (
+	// ErrNoCredential is returned by an Authenticator if the request does not carry a credential of its kind
+	ErrNoCredential = errors.New("no credential")
+	// ErrInvalidCredential is returned by an Authenticator if the credential of its kind is wrong
+	ErrInvalidCredential = errors.New("invalid credential")
+)
+
+// Authenticator identifies the caller of a request, it returns ErrNoCredential if the request does not carry
+// a credential of its kind, so that the next authenticator can be tried, and ErrInvalidCredential or another
+// error to reject the request
+type Authenticator interface {
+	Authenticate(req *http.Request) (identity string, err error)
+}
+
+// AuthConfig maps the credentials to the identities of the callers, identity is used in logs and metrics
+type AuthConfig struct {
+	// BearerTokens are checked against "Authorization: Bearer {token}" header
+	BearerTokens map[string]string `json:"bearer_tokens"`
+	// PathKeys are checked against the first path segment, e.g. http://proxy/{key}, the key is removed before forwarding
+	PathKeys map[string]string `json:"path_keys"`
+	// HmacSecrets maps the key in X-Proxy-Key header to the secret,
+	// X-Proxy-Signature is hex(hmac-sha256(secret, X-Proxy-Timestamp + "\n" + body))
+	HmacSecrets map[string]string `json:"hmac_secrets"`
+	// Authenticators are custom authenticators, tried after the ones above
+	Authenticators []Authenticator `json:"-"`
+}
+
+func (c *AuthConfig) authenticators() []Authenticator {
+	var auths []Authenticator
+	if len(c.BearerTokens) > 0 {
+		auths = append(auths, BearerTokenAuth(c.BearerTokens))
+	}
+	if len(c.PathKeys) > 0 {
+		auths = append(auths, PathKeyAuth(c.PathKeys))
+	}
+	if len(c.HmacSecrets) > 0 {
+		auths = append(auths, HmacAuth(c.HmacSecrets))
+	}
+	return append(auths, c.Authenticators...)
+}
+
+// BearerTokenAuth maps the bearer token to the identity
+type BearerTokenAuth map[string]string
+
+func (a BearerTokenAuth) Authenticate(req *http.Request) (string, error) {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", ErrNoCredential
+	}
+	identity, ok := lookupCredential(a, strings.TrimPrefix(header, "Bearer "))
+	if !ok {
+		return "", ErrInvalidCredential
+	}
+	// the token is for the proxy, do not pass it to the upstream
+	req.Header.Del("Authorization")
+	return identity, nil
+}
+
+// lookupCredential returns the identity of the credential, it compares with all the credentials in constant time
+// so that the time does not tell how close a guess is
+func lookupCredential(credentials map[string]string, credential string) (identity string, ok bool) {
+	sum := sha256.Sum256([]byte(credential))
+	for k, v := range credentials {
+		kSum := sha256.Sum256([]byte(k))
+		if subtle.ConstantTimeCompare(sum[:], kSum[:]) == 1 {
+			identity, ok = v, true
+		}
+	}
+	return identity, ok
+}
+
+// PathKeyAuth maps the key in the first path segment to the identity, a path not starting with a key carries
+// no credential of it
+type PathKeyAuth map[string]string
+
+func (a PathKeyAuth) Authenticate(req *http.Request) (string, error) {
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	if path == "" {
+		return "", ErrNoCredential
+	}
+	key, rest := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		key, rest = path[:i], path[i:]
+	}
+	identity, ok := lookupCredential(a, key)
+	if !ok {
+		return "", ErrNoCredential
+	}
+	req.URL.Path = rest
+	req.URL.RawPath = ""
+	return identity, nil
+}
+
+// HmacAuth maps the key in X-Proxy-Key header to the secret, the key is used as identity
+type HmacAuth map[string]string
+
+func (a HmacAuth) Authenticate(req *http.Request) (string, error) {
+	key := req.Header.Get(HeaderHmacKey)
+	if key == "" {
+		return "", ErrNoCredential
+	}
+	secret, ok := a[key]
+	if !ok {
+		return "", ErrInvalidCredential
+	}
+	ts, err := strconv.ParseInt(req.Header.Get(HeaderHmacTimestamp), 10, 64)
+	if err != nil {
+		return "", ErrInvalidCredential
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > hmacMaxClockSkew || skew < -hmacMaxClockSkew {
+		return "", ErrInvalidCredential
+	}
+	sig, err := hex.DecodeString(req.Header.Get(HeaderHmacSignature))
+	if err != nil {
+		return "", ErrInvalidCredential
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return "", err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if !hmac.Equal(sig, HmacSignature(secret, ts, body)) {
+		return "", ErrInvalidCredential
+	}
+	return key, nil
+}
+
+// HmacSignature returns the signature of the request body for HmacAuth
+func HmacSignature(secret string, timestamp int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "\n"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+type clientIdentityKey struct{}
+
+// clientIdentity returns the identity set by the auth handler, or the remote ip if there is no auth
+func clientIdentity(req *http.Request) string {
+	if identity, ok := req.Context().Value(clientIdentityKey{}).(string); ok {
+		return identity
+	}
+	host := req.RemoteAddr
+	if i := strings.LastIndex(host, ":"); i > 0 {
+		host = host[:i]
+	}
+	return host
+}
+
+// authHandler only passes the requests accepted by one of the authenticators, with the identity in request context
+func authHandler(auths []Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, auth := range auths {
+			identity, err := auth.Authenticate(req)
+			if errors.Is(err, ErrNoCredential) {
+				continue
+			}
+			if err != nil {
+				log.Warnf("reject request from %s, err:%s", req.RemoteAddr, err.Error())
+				incCounter(1, "auth", "rejected")
+				writeJsonRpcError(w, http.StatusUnauthorized, nil, errCodeUnauthorized, "unauthorized", nil)
+				return
+			}
+			incCounter(1, "client", identity, "requests")
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), clientIdentityKey{}, identity)))
+			return
+		}
+		log.Warnf("reject request without credential from %s", req.RemoteAddr)
+		incCounter(1, "auth", "rejected")
+		writeJsonRpcError(w, http.StatusUnauthorized, nil, errCodeUnauthorized, "unauthorized", nil)
+	})
+}
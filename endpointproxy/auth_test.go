@@ -0,0 +1,76 @@
+package endpointproxy
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthHandler(t *testing.T) {
+	cfg := &AuthConfig{
+		BearerTokens: map[string]string{"token": "bearer-client"},
+		PathKeys:     map[string]string{"key": "path-client"},
+		HmacSecrets:  map[string]string{"hmac-client": "secret"},
+	}
+	body := `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`
+	ts := time.Now().Unix()
+	signature := hex.EncodeToString(HmacSignature("secret", ts, []byte(body)))
+	tests := []struct {
+		name         string
+		path         string
+		headers      map[string]string
+		wantIdentity string
+		wantPath     string
+	}{
+		{"bearer", "/", map[string]string{"Authorization": "Bearer token"}, "bearer-client", "/"},
+		{"invalid bearer", "/", map[string]string{"Authorization": "Bearer tok"}, "", ""},
+		{"path key", "/key/rpc", nil, "path-client", "/rpc"},
+		{"unknown path key", "/other", nil, "", ""},
+		{"unknown path key with bearer", "/rpc", map[string]string{"Authorization": "Bearer token"}, "bearer-client", "/rpc"},
+		{"hmac", "/", map[string]string{HeaderHmacKey: "hmac-client", HeaderHmacTimestamp: strconv.FormatInt(ts, 10),
+			HeaderHmacSignature: signature}, "hmac-client", "/"},
+		{"invalid hmac", "/", map[string]string{HeaderHmacKey: "hmac-client", HeaderHmacTimestamp: strconv.FormatInt(ts+1, 10),
+			HeaderHmacSignature: signature}, "", ""},
+		{"no credential", "/", nil, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var identity, path, authorization string
+			next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				identity, path, authorization = clientIdentity(req), req.URL.Path, req.Header.Get("Authorization")
+			})
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			authHandler(cfg.authenticators(), next).ServeHTTP(rec, req)
+			if tt.wantIdentity == "" {
+				if rec.Code != http.StatusUnauthorized || identity != "" {
+					t.Errorf("status %d, identity %s, want rejected", rec.Code, identity)
+				}
+				return
+			}
+			if identity != tt.wantIdentity || path != tt.wantPath {
+				t.Errorf("identity %s, path %s, want %s, %s", identity, path, tt.wantIdentity, tt.wantPath)
+			}
+			if authorization != "" {
+				t.Errorf("authorization header %s is forwarded", authorization)
+			}
+		})
+	}
+}
+
+func TestLookupCredential(t *testing.T) {
+	credentials := map[string]string{"key1": "a", "key2": "b"}
+	for credential, want := range map[string]string{"key1": "a", "key2": "b", "key": "", "key12": "", "": ""} {
+		identity, ok := lookupCredential(credentials, credential)
+		if identity != want || ok != (want != "") {
+			t.Errorf("lookupCredential(%s) = %s, %v, want %s", credential, identity, ok, want)
+		}
+	}
+}
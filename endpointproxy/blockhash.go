@@ -0,0 +1,177 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	// computedHashField is added to the blocks whose header does not hash to the returned hash
+	computedHashField = "proxyComputedHash"
+)
+
+// BlockHashCheckConfig checks if the header of the blocks returned by the proxy, i.e. after the fixups and
+// normalization, hashes to the returned hash. The mismatches are counted by chain in metrics, so that the
+// lossy fixups are known.
+type BlockHashCheckConfig struct {
+	// Annotate adds the computed hash as proxyComputedHash to the mismatched blocks
+	Annotate bool `json:"annotate"`
+	// Chains limits the check to the chain ids in it, empty means all chains
+	Chains []uint64 `json:"chains"`
+}
+
+func (c *BlockHashCheckConfig) enabled(chainId uint64) bool {
+	if c == nil {
+		return false
+	}
+	if len(c.Chains) == 0 {
+		return true
+	}
+	for _, id := range c.Chains {
+		if id == chainId {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonHeader is the block header with the fields added after eth client, in the order of the header rlp
+type jsonHeader struct {
+	ParentHash            common.Hash      `json:"parentHash"`
+	UncleHash             common.Hash      `json:"sha3Uncles"`
+	Coinbase              common.Address   `json:"miner"`
+	Root                  common.Hash      `json:"stateRoot"`
+	TxHash                common.Hash      `json:"transactionsRoot"`
+	ReceiptHash           common.Hash      `json:"receiptsRoot"`
+	Bloom                 types.Bloom      `json:"logsBloom"`
+	Difficulty            *hexutil.Big     `json:"difficulty"`
+	Number                *hexutil.Big     `json:"number"`
+	GasLimit              hexutil.Uint64   `json:"gasLimit"`
+	GasUsed               hexutil.Uint64   `json:"gasUsed"`
+	Time                  hexutil.Uint64   `json:"timestamp"`
+	Extra                 hexutil.Bytes    `json:"extraData"`
+	MixDigest             common.Hash      `json:"mixHash"`
+	Nonce                 types.BlockNonce `json:"nonce"`
+	BaseFee               *hexutil.Big     `json:"baseFeePerGas"`
+	WithdrawalsHash       *common.Hash     `json:"withdrawalsRoot"`
+	BlobGasUsed           *hexutil.Uint64  `json:"blobGasUsed"`
+	ExcessBlobGas         *hexutil.Uint64  `json:"excessBlobGas"`
+	ParentBeaconBlockRoot *common.Hash     `json:"parentBeaconBlockRoot"`
+	RequestsHash          *common.Hash     `json:"requestsHash"`
+}
+
+// blockHash computes the hash of the header of the block, ok is false if the header can not be hashed,
+// e.g. a required field is missing
+func blockHash(block json.RawMessage) (common.Hash, bool) {
+	var h jsonHeader
+	if err := json.Unmarshal(block, &h); err != nil || h.Difficulty == nil || h.Number == nil {
+		return common.Hash{}, false
+	}
+	fields := []interface{}{
+		h.ParentHash, h.UncleHash, h.Coinbase, h.Root, h.TxHash, h.ReceiptHash, h.Bloom, h.Difficulty.ToInt(),
+		h.Number.ToInt(), uint64(h.GasLimit), uint64(h.GasUsed), uint64(h.Time), []byte(h.Extra), h.MixDigest, h.Nonce,
+	}
+	optional := make([]interface{}, 6)
+	if h.BaseFee != nil {
+		optional[0] = h.BaseFee.ToInt()
+	}
+	if h.WithdrawalsHash != nil {
+		optional[1] = *h.WithdrawalsHash
+	}
+	if h.BlobGasUsed != nil {
+		optional[2] = uint64(*h.BlobGasUsed)
+	}
+	if h.ExcessBlobGas != nil {
+		optional[3] = uint64(*h.ExcessBlobGas)
+	}
+	if h.ParentBeaconBlockRoot != nil {
+		optional[4] = *h.ParentBeaconBlockRoot
+	}
+	if h.RequestsHash != nil {
+		optional[5] = *h.RequestsHash
+	}
+	// the optional fields are encoded up to the last present one, which needs all the ones before it
+	last := -1
+	for i, v := range optional {
+		if v != nil {
+			last = i
+		}
+	}
+	for _, v := range optional[:last+1] {
+		if v == nil {
+			return common.Hash{}, false
+		}
+		fields = append(fields, v)
+	}
+	data, err := rlp.EncodeToBytes(fields)
+	if err != nil {
+		return common.Hash{}, false
+	}
+	return crypto.Keccak256Hash(data), true
+}
+
+// blockHashCheckHandler checks the hashes of the blocks returned by next, the responses are only changed
+// by the annotation
+func blockHashCheckHandler(cfg *BlockHashCheckConfig, chainId uint64, next http.Handler) http.Handler {
+	chain := strconv.FormatUint(chainId, 10)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := readReqBody(req)
+		if err != nil {
+			writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, "invalid request", nil)
+			return
+		}
+		msgs, batch, err := parseJsonRpcBody(body)
+		if err != nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		calls := make(map[string]bool)
+		for _, msg := range msgs {
+			if len(msg.ID) > 0 && string(msg.ID) != "null" &&
+				(msg.Method == MethodEthGetBlockByNumber || msg.Method == MethodEthGetBlockByHash) {
+				calls[string(msg.ID)] = true
+			}
+		}
+		if len(calls) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		serveRewritten(w, req, next, msgs, batch, func(resp *jsonrpcMessage) bool {
+			if !calls[string(resp.ID)] || resp.Error != nil || len(resp.Result) == 0 || string(resp.Result) == "null" {
+				return false
+			}
+			var block jsonObject
+			if json.Unmarshal(resp.Result, &block) != nil || block.missing("hash") {
+				// pending block
+				return false
+			}
+			hash, ok := blockHash(resp.Result)
+			if !ok {
+				incCounter(1, "blockhash", chain, "unchecked")
+				return false
+			}
+			if common.HexToHash(block.getString("hash")) == hash {
+				incCounter(1, "blockhash", chain, "match")
+				return false
+			}
+			incCounter(1, "blockhash", chain, "mismatch")
+			if !cfg.Annotate {
+				return false
+			}
+			block.set(computedHashField, hash)
+			data, err := json.Marshal(block)
+			if err != nil {
+				return false
+			}
+			resp.Result = data
+			return true
+		})
+	})
+}
@@ -0,0 +1,74 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestBlockHash(t *testing.T) {
+	header := func(baseFee *big.Int) *types.Header {
+		return &types.Header{
+			ParentHash:  common.HexToHash("0x01"),
+			UncleHash:   types.EmptyUncleHash,
+			Coinbase:    common.HexToAddress("0x0b585f8daefbc68a311fbd4cb20d9174ad174016"),
+			Root:        common.HexToHash("0x02"),
+			TxHash:      types.EmptyRootHash,
+			ReceiptHash: types.EmptyRootHash,
+			Difficulty:  big.NewInt(131072),
+			Number:      big.NewInt(15537394),
+			GasLimit:    30000000,
+			GasUsed:     21000,
+			Time:        1663224179,
+			Extra:       []byte("proxy"),
+			MixDigest:   common.HexToHash("0x03"),
+			Nonce:       types.EncodeNonce(42),
+			BaseFee:     baseFee,
+		}
+	}
+	tests := []struct {
+		name   string
+		header *types.Header
+	}{
+		{"legacy", header(nil)},
+		{"london", header(big.NewInt(7))},
+		{"zero base fee", header(big.NewInt(0))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, err := json.Marshal(tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash, ok := blockHash(block)
+			if !ok {
+				t.Fatalf("header %s can not be hashed", block)
+			}
+			if want := tt.header.Hash(); hash != want {
+				t.Errorf("blockHash = %s, want %s", hash, want)
+			}
+		})
+	}
+}
+
+func TestBlockHashInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+	}{
+		{"not an object", `"0x01"`},
+		{"no difficulty", `{"number":"0x1"}`},
+		{"no number", `{"difficulty":"0x1"}`},
+		{"gap in optional fields", `{"difficulty":"0x1","number":"0x1","withdrawalsRoot":"0x0000000000000000000000000000000000000000000000000000000000000000"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := blockHash(json.RawMessage(tt.block)); ok {
+				t.Errorf("blockHash(%s) is ok, want not ok", tt.block)
+			}
+		})
+	}
+}
@@ -0,0 +1,525 @@
+package endpointproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celer-network/goutils/log"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultCacheSize     = 10000
+	defaultFinalityDepth = 64
+
+	// the head is refreshed before checking the finality of a block if it is older than this
+	headMaxAge = 10 * time.Second
+)
+
+// CacheConfig caches the responses of immutable queries in memory, i.e. queries by block or tx hash,
+// and queries by block number at least FinalityDepth blocks below the head. The responses are cached
+// after the fixups of the chain, a cache hit is answered without calling the upstream.
+type CacheConfig struct {
+	// Size is the max number of cached responses, the least recently used ones are evicted, default is 10000
+	Size int `json:"size"`
+	// FinalityDepth is the number of blocks below the head which can still be reorged, default is 64,
+	// set it to 1 for the chains with instant finality
+	FinalityDepth uint64 `json:"finality_depth"`
+	// HeadTTLMs caches the results of HeadMethods for the milliseconds, they change with the head so it should
+	// be short, e.g. 500, they are not cached if it is 0
+	HeadTTLMs int `json:"head_ttl_ms"`
+	// HeadMethods are the head dependent methods cached by HeadTTLMs, default is eth_blockNumber, eth_gasPrice,
+	// eth_maxPriorityFeePerGas and eth_feeHistory
+	HeadMethods []string `json:"head_methods"`
+	// Coalesce lets the identical in-flight calls of the cached methods share one upstream call, only single
+	// call requests are coalesced
+	Coalesce bool `json:"coalesce"`
+	// Disk is optional, the immutable results are only kept in memory if it is nil
+	Disk *DiskCacheConfig `json:"disk"`
+	// ReorgPollMs polls the head block at most every milliseconds while the proxy is in use, and tracks the hashes
+	// of the recent blocks to detect reorgs, the cached responses of reorged blocks are evicted. With it, FinalityDepth
+	// can be small to cache the recent blocks. Reorgs are not tracked if it is 0
+	ReorgPollMs int `json:"reorg_poll_ms"`
+	// ReorgWindow is the number of recent blocks tracked, reorgs deeper than it are not detected, default is 128
+	ReorgWindow uint64 `json:"reorg_window"`
+}
+
+var defaultHeadMethods = []string{MethodEthBlockNumber, MethodEthGasPrice, MethodEthMaxPriorityFeePerGas, MethodEthFeeHistory}
+
+// cacheRule tells how the calls of a method are cached
+type cacheRule struct {
+	// blockParam is the index of the block number or hash param, -1 if the call does not refer to a block
+	blockParam int
+	// resultBlock is true if the block of the result must be final, e.g. a tx is mined but may be reorged
+	resultBlock bool
+}
+
+var cacheRules = map[string]cacheRule{
+	"eth_chainId":                                {blockParam: -1},
+	"net_version":                                {blockParam: -1},
+	MethodEthGetBlockByHash:                      {blockParam: -1},
+	"eth_getBlockTransactionCountByHash":         {blockParam: -1},
+	MethodEthGetTransactionByBlockHashAndIndex:   {blockParam: -1},
+	"eth_getUncleCountByBlockHash":               {blockParam: -1},
+	"eth_getUncleByBlockHashAndIndex":            {blockParam: -1},
+	MethodEthGetTransactionByHash:                {blockParam: -1, resultBlock: true},
+	MethodEthGetTransactionReceipt:               {blockParam: -1, resultBlock: true},
+	MethodEthGetBlockByNumber:                    {blockParam: 0},
+	"eth_getBlockTransactionCountByNumber":       {blockParam: 0},
+	MethodEthGetTransactionByBlockNumberAndIndex: {blockParam: 0},
+	"eth_getUncleCountByBlockNumber":             {blockParam: 0},
+	"eth_getUncleByBlockNumberAndIndex":          {blockParam: 0},
+	MethodEthGetBlockReceipts:                    {blockParam: 0},
+	"eth_getBalance":                             {blockParam: 1},
+	"eth_getCode":                                {blockParam: 1},
+	"eth_getTransactionCount":                    {blockParam: 1},
+	"eth_call":                                   {blockParam: 1},
+	"eth_getStorageAt":                           {blockParam: 2},
+}
+
+// headTracker keeps the latest block number seen from the upstream
+type headTracker struct {
+	lock    sync.Mutex
+	number  uint64
+	updated time.Time
+}
+
+func (h *headTracker) get() (uint64, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.number, time.Since(h.updated) < headMaxAge
+}
+
+func (h *headTracker) set(number uint64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	// a lagging upstream node may report an older head, keep the newer one unless it is stale
+	if number >= h.number || time.Since(h.updated) >= headMaxAge {
+		h.number = number
+		h.updated = time.Now()
+	}
+}
+
+// responseCache is a LRU cache of the results of immutable calls, and of head dependent calls for a short ttl
+type responseCache struct {
+	chainId       uint64
+	finalityDepth uint64
+	results       *lru.Cache
+	disk          *diskStore
+	head          headTracker
+
+	headMethods map[string]bool
+	headTTL     time.Duration
+	recent      *lru.Cache
+
+	coalescing bool
+	flights    singleflight.Group
+
+	// nil if reorgs are not tracked
+	reorgs *reorgTracker
+}
+
+type recentResult struct {
+	result  json.RawMessage
+	expires time.Time
+}
+
+func newResponseCache(cfg *CacheConfig, chainId uint64) (*responseCache, error) {
+	size := cfg.Size
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	depth := cfg.FinalityDepth
+	if depth == 0 {
+		depth = defaultFinalityDepth
+	}
+	methods := cfg.HeadMethods
+	if len(methods) == 0 {
+		methods = defaultHeadMethods
+	}
+	headMethods := make(map[string]bool)
+	for _, method := range methods {
+		headMethods[method] = true
+	}
+	var disk *diskStore
+	if cfg.Disk != nil {
+		var err error
+		if disk, err = openDiskStore(cfg.Disk); err != nil {
+			return nil, err
+		}
+	}
+	// it only fails on non-positive size
+	results, _ := lru.New(size)
+	recent, _ := lru.New(size)
+	c := &responseCache{
+		chainId:       chainId,
+		finalityDepth: depth,
+		results:       results,
+		disk:          disk,
+		headMethods:   headMethods,
+		headTTL:       time.Duration(cfg.HeadTTLMs) * time.Millisecond,
+		recent:        recent,
+		coalescing:    cfg.Coalesce,
+	}
+	if cfg.ReorgPollMs > 0 {
+		c.reorgs = newReorgTracker(c, cfg)
+	}
+	return c, nil
+}
+
+// cacheKey returns the key of the call by chain, method and normalized params
+func (c *responseCache) cacheKey(msg *jsonrpcMessage) (string, bool) {
+	var params interface{}
+	if len(msg.Params) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(msg.Params))
+		dec.UseNumber()
+		if err := dec.Decode(&params); err != nil {
+			return "", false
+		}
+	}
+	normalized, err := json.Marshal(normalizeParam(params))
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%d/%s/%s", c.chainId, msg.Method, normalized), true
+}
+
+// normalizeParam lowercases the hex strings, hashes and addresses are case insensitive
+func normalizeParam(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if strings.HasPrefix(v, "0x") || strings.HasPrefix(v, "0X") {
+			return strings.ToLower(v)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeParam(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = normalizeParam(v[k])
+		}
+	}
+	return v
+}
+
+// callBlock returns the block number which must be final for caching the call, needFinal is false
+// if the call is immutable by itself, ok is false if the call can't be cached, e.g. a query of "latest"
+func callBlock(msg *jsonrpcMessage) (number uint64, needFinal bool, ok bool) {
+	if msg.Method == MethodEthGetLogs {
+		var args []filterArg
+		if err := json.Unmarshal(msg.Params, &args); err != nil || len(args) == 0 {
+			return 0, false, false
+		}
+		if args[0].BlockHash != nil {
+			return 0, false, true
+		}
+		_, to, ok := getLogsRange(msg.Params)
+		return to, true, ok
+	}
+	rule, ok := cacheRules[msg.Method]
+	if !ok {
+		return 0, false, false
+	}
+	if rule.blockParam < 0 {
+		return 0, false, true
+	}
+	var params []json.RawMessage
+	if err := json.Unmarshal(msg.Params, &params); err != nil || len(params) <= rule.blockParam {
+		// the block param defaults to "latest"
+		return 0, false, false
+	}
+	return parseBlockParam(params[rule.blockParam])
+}
+
+// parseBlockParam parses a block number, a block hash, or the EIP-1898 block object
+func parseBlockParam(param json.RawMessage) (number uint64, needFinal bool, ok bool) {
+	var s string
+	if err := json.Unmarshal(param, &s); err == nil {
+		if len(s) == 66 {
+			return 0, false, true
+		}
+		number, ok = parseBlockNumber(&s)
+		return number, true, ok
+	}
+	var obj struct {
+		BlockHash   *string `json:"blockHash"`
+		BlockNumber *string `json:"blockNumber"`
+	}
+	if err := json.Unmarshal(param, &obj); err != nil {
+		return 0, false, false
+	}
+	if obj.BlockHash != nil {
+		return 0, false, true
+	}
+	number, ok = parseBlockNumber(obj.BlockNumber)
+	return number, true, ok
+}
+
+// final checks if the block can't be reorged, the head is refreshed from the upstream if it is stale
+func (c *responseCache) final(req *http.Request, next http.Handler, number uint64) bool {
+	head, fresh := c.head.get()
+	if !fresh {
+		result, err := callMethod(req, next, MethodEthBlockNumber)
+		if err != nil {
+			log.Warnf("fail to refresh head, chain:%d, err:%s", c.chainId, err.Error())
+			return false
+		}
+		c.observe(&jsonrpcMessage{Method: MethodEthBlockNumber}, &jsonrpcMessage{Result: result})
+		if head, fresh = c.head.get(); !fresh {
+			return false
+		}
+	}
+	return number+c.finalityDepth <= head
+}
+
+// observe learns the head from the responses of eth_blockNumber
+func (c *responseCache) observe(call, resp *jsonrpcMessage) {
+	if call.Method != MethodEthBlockNumber || resp.Error != nil {
+		return
+	}
+	var number hexutil.Uint64
+	if err := json.Unmarshal(resp.Result, &number); err == nil {
+		c.head.set(uint64(number))
+	}
+}
+
+// cacheable checks the response of a call, errors and null results, e.g. unknown blocks, are not cached
+func (c *responseCache) cacheable(req *http.Request, next http.Handler, call, resp *jsonrpcMessage) bool {
+	if resp.Error != nil || len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return false
+	}
+	if !cacheRules[call.Method].resultBlock {
+		return true
+	}
+	var result struct {
+		BlockNumber *string `json:"blockNumber"`
+	}
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return false
+	}
+	// pending tx has no block number
+	number, ok := parseBlockNumber(result.BlockNumber)
+	return ok && c.final(req, next, number)
+}
+
+// lookup returns the cached result of the call, key is empty if the call can't be cached
+func (c *responseCache) lookup(req *http.Request, next http.Handler, msg *jsonrpcMessage) (key string, result json.RawMessage, found bool) {
+	if c.headMethods[msg.Method] {
+		key, ok := c.cacheKey(msg)
+		if !ok {
+			return "", nil, false
+		}
+		if c.headTTL > 0 {
+			if v, ok := c.recent.Get(key); ok {
+				if r := v.(*recentResult); time.Now().Before(r.expires) {
+					return key, r.result, true
+				}
+			}
+		}
+		return key, nil, false
+	}
+	number, needFinal, ok := callBlock(msg)
+	if !ok || (needFinal && !c.final(req, next, number)) {
+		return "", nil, false
+	}
+	if key, ok = c.cacheKey(msg); !ok {
+		return "", nil, false
+	}
+	if v, ok := c.results.Get(key); ok {
+		return key, v.(json.RawMessage), true
+	}
+	if c.disk != nil {
+		if result, ok := c.disk.get(key); ok {
+			incCounter(1, "cache", "disk", "hit")
+			c.results.Add(key, result)
+			return key, result, true
+		}
+	}
+	return key, nil, false
+}
+
+// store caches the response of a missed call
+func (c *responseCache) store(req *http.Request, next http.Handler, key string, call, resp *jsonrpcMessage) {
+	c.observe(call, resp)
+	if key == "" {
+		return
+	}
+	if c.headMethods[call.Method] {
+		if c.headTTL > 0 && resp.Error == nil && len(resp.Result) > 0 {
+			c.recent.Add(key, &recentResult{result: resp.Result, expires: time.Now().Add(c.headTTL)})
+		}
+		return
+	}
+	if !c.cacheable(req, next, call, resp) {
+		return
+	}
+	c.results.Add(key, resp.Result)
+	tracked := false
+	if c.reorgs != nil {
+		if from, to, ok := responseBlocks(call, resp); ok {
+			tracked = c.reorgs.track(key, from, to)
+		}
+	}
+	// the tracked blocks are only known in memory, their responses would survive the reorgs on disk
+	if c.disk != nil && !tracked {
+		c.disk.add(key, resp.Result)
+	}
+}
+
+// responseBlocks returns the range of blocks the response depends on, ok is false if it does not change
+// with reorgs, e.g. a block by hash
+func responseBlocks(call, resp *jsonrpcMessage) (from, to uint64, ok bool) {
+	if call.Method == MethodEthGetLogs {
+		return getLogsRange(call.Params)
+	}
+	if cacheRules[call.Method].resultBlock {
+		var result struct {
+			BlockNumber *string `json:"blockNumber"`
+		}
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return 0, 0, false
+		}
+		number, ok := parseBlockNumber(result.BlockNumber)
+		return number, number, ok
+	}
+	number, needFinal, ok := callBlock(call)
+	return number, number, ok && needFinal
+}
+
+// evict removes the cached responses, and the recent head dependent ones
+func (c *responseCache) evict(keys []string) {
+	for _, key := range keys {
+		c.results.Remove(key)
+	}
+	if c.disk != nil && len(keys) > 0 {
+		c.disk.remove(keys)
+	}
+	c.recent.Purge()
+}
+
+// coalesce lets the identical in-flight calls share the response of one upstream call
+func (c *responseCache) coalesce(w http.ResponseWriter, req *http.Request, next http.Handler, key string, msg *jsonrpcMessage) {
+	leader := false
+	v, _, _ := c.flights.Do(key, func() (interface{}, error) {
+		leader = true
+		resps, buf := forwardCalls(req, next, []*jsonrpcMessage{msg}, false)
+		if resps != nil {
+			c.store(req, next, key, msg, resps[0])
+		}
+		return &flight{resps: resps, buf: buf}, nil
+	})
+	f := v.(*flight)
+	if !leader {
+		incCounter(1, "cache", msg.Method, "coalesced")
+	}
+	if f.resps == nil {
+		f.buf.writeTo(w, f.buf.body.Bytes())
+		return
+	}
+	// the response is shared, answer with the id of this call
+	resp := *f.resps[0]
+	resp.ID = msg.ID
+	data, _ := json.Marshal(&resp)
+	f.buf.writeTo(w, data)
+}
+
+type flight struct {
+	resps []*jsonrpcMessage
+	buf   *responseBuffer
+}
+
+type cacheMiss struct {
+	call *jsonrpcMessage
+	key  string
+}
+
+// cacheHandler answers the calls of immutable queries and recent head queries from the cache,
+// only the missed calls of a batch are forwarded
+func cacheHandler(cfg *CacheConfig, chainId uint64, next http.Handler) (http.Handler, error) {
+	c, err := newResponseCache(cfg, chainId)
+	if err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if c.reorgs != nil {
+			c.reorgs.maybePoll(next)
+		}
+		body, err := readReqBody(req)
+		if err != nil {
+			writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, "invalid request", nil)
+			return
+		}
+		msgs, batch, err := parseJsonRpcBody(body)
+		// the blocks of other harmony shards are not tracked by the cache
+		if err != nil || len(msgs) == 0 || req.Header.Get(HarmonyShardHeader) != "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		var hits, forwarded []*jsonrpcMessage
+		// the missed calls by id, their responses are cached
+		misses := make(map[string]*cacheMiss)
+		// false if there is nothing to cache or learn from the responses
+		useful := false
+		for _, msg := range msgs {
+			// notifications have no response to cache
+			if len(msg.ID) == 0 || string(msg.ID) == "null" {
+				forwarded = append(forwarded, msg)
+				continue
+			}
+			key, result, found := c.lookup(req, next, msg)
+			if found {
+				incCounter(1, "cache", msg.Method, "hit")
+				hits = append(hits, &jsonrpcMessage{Version: "2.0", ID: msg.ID, Result: result})
+				continue
+			}
+			if key != "" {
+				incCounter(1, "cache", msg.Method, "miss")
+			}
+			misses[string(msg.ID)] = &cacheMiss{call: msg, key: key}
+			forwarded = append(forwarded, msg)
+			useful = useful || key != "" || msg.Method == MethodEthBlockNumber
+		}
+		if len(hits) == 0 && !useful {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if len(forwarded) == 0 {
+			if batch {
+				writeJsonRpcResponse(w, http.StatusOK, hits)
+			} else {
+				writeJsonRpcResponse(w, http.StatusOK, hits[0])
+			}
+			return
+		}
+		if !batch && c.coalescing {
+			if miss := misses[string(msgs[0].ID)]; miss != nil && miss.key != "" {
+				c.coalesce(w, req, next, miss.key, miss.call)
+				return
+			}
+		}
+		resps, buf := forwardCalls(req, next, forwarded, batch)
+		if resps == nil {
+			buf.writeTo(w, buf.body.Bytes())
+			return
+		}
+		for _, resp := range resps {
+			if miss, ok := misses[string(resp.ID)]; ok {
+				c.store(req, next, miss.key, miss.call, resp)
+			}
+		}
+		if len(hits) == 0 {
+			buf.writeTo(w, buf.body.Bytes())
+			return
+		}
+		merged, _ := json.Marshal(append(resps, hits...))
+		buf.writeTo(w, merged)
+	}), nil
+}
@@ -1,10 +1,9 @@
 package endpointproxy
 
 import (
-	"bytes"
-	"compress/gzip"
 	"encoding/json"
-	"io/ioutil"
+	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -12,11 +11,6 @@ import (
 	"github.com/celer-network/goutils/log"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/core/types"
-)
-
-const (
-	celoHeaderRpcMethod = "header-rpc-method"
 )
 
 type CeloProxy struct {
@@ -24,11 +18,11 @@ type CeloProxy struct {
 }
 
 // NewProxy takes target host and creates a reverse proxy
-func (c *CeloProxy) startCeloProxy(targetHost string, port int, chainId uint64) error {
+func (c *CeloProxy) newCeloProxy(targetHost string) (*httputil.ReverseProxy, error) {
 	var err error
 	c.celoTargetUrl, err = url.Parse(targetHost)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	p := httputil.NewSingleHostReverseProxy(c.celoTargetUrl)
 	originalDirector := p.Director
@@ -36,98 +30,214 @@ func (c *CeloProxy) startCeloProxy(targetHost string, port int, chainId uint64)
 		originalDirector(req)
 		c.modifyCeloRequest(req)
 	}
-	p.ModifyResponse = modifyCeloResponse()
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", proxyRequestHandler(p))
-	go startCustomProxyByPort(port, mux, chainId, targetHost)
-	return nil
+	return p, nil
 }
 
 func (c *CeloProxy) modifyCeloRequest(req *http.Request) {
 	req.URL.Scheme = c.celoTargetUrl.Scheme
 	req.URL.Host = c.celoTargetUrl.Host
 	req.Host = c.celoTargetUrl.Host
-	reqStr, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		log.Warnf("invalid celo request err:%s", err.Error())
-		return
+}
+
+// CeloConfig sets the fee mode of the celo proxies
+type CeloConfig struct {
+	// FeeCurrency is the address of the fee currency the fee related calls are answered in, i.e. eth_gasPrice,
+	// eth_maxPriorityFeePerGas and eth_feeHistory, the native CELO is used if it is empty
+	FeeCurrency string `json:"fee_currency"`
+}
+
+func (c *CeloConfig) feeCurrency() (*common.Address, error) {
+	if c == nil || c.FeeCurrency == "" {
+		return nil, nil
 	}
-	var msg jsonrpcMessage
-	if err = json.Unmarshal(reqStr, &msg); err != nil {
-		log.Warnf("fail to unmarshal this celo req body err:%s", err.Error())
-		return
+	if !common.IsHexAddress(c.FeeCurrency) {
+		return nil, fmt.Errorf("invalid celo fee currency %s", c.FeeCurrency)
 	}
-	req.Header.Set(celoHeaderRpcMethod, msg.Method)
-	req.Body = ioutil.NopCloser(bytes.NewReader(reqStr))
+	currency := common.HexToAddress(c.FeeCurrency)
+	return &currency, nil
 }
 
-func modifyCeloResponse() func(*http.Response) error {
-	return func(resp *http.Response) error {
-		if resp.Request != nil && resp.Request.Header.Get(celoHeaderRpcMethod) == MethodEthGetBlockByNumber {
-			gzipReader, err := gzip.NewReader(resp.Body)
-			if err != nil {
-				return err
-			}
-			originData, err := ioutil.ReadAll(gzipReader)
-			if err != nil {
-				return err
-			}
-			var msg jsonrpcMessage
-			if err = json.Unmarshal(originData, &msg); err != nil {
-				return err
-			}
-			var result Header
-			if err = json.Unmarshal(msg.Result, &result); err != nil {
-				return err
-			}
-			if result.UncleHash == nil {
-				result.UncleHash = &types.EmptyUncleHash
+// celoFeeHistory is eth_feeHistory result, the fields are checked one by one as celo nodes may leave some out
+type celoFeeHistory struct {
+	OldestBlock  json.RawMessage  `json:"oldestBlock"`
+	BaseFee      []*hexutil.Big   `json:"baseFeePerGas"`
+	GasUsedRatio []float64        `json:"gasUsedRatio"`
+	Reward       [][]*hexutil.Big `json:"reward,omitempty"`
+}
+
+// celoFeeHandler answers the fee related calls in the fee currency if any, and normalizes eth_feeHistory results
+// so that eth client can decode them
+func celoFeeHandler(currency *common.Address, chainId uint64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := readReqBody(req)
+		if err != nil {
+			writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, "invalid request", nil)
+			return
+		}
+		msgs, batch, err := parseJsonRpcBody(body)
+		if err != nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		calls := make(map[string]*jsonrpcMessage)
+		for _, msg := range msgs {
+			if len(msg.ID) == 0 || string(msg.ID) == "null" {
+				continue
 			}
-			if result.Difficulty == nil {
-				result.Difficulty = &hexutil.Big{}
+			switch msg.Method {
+			case MethodEthGasPrice, MethodEthMaxPriorityFeePerGas:
+				if currency != nil {
+					var params []json.RawMessage
+					if len(msg.Params) == 0 || (json.Unmarshal(msg.Params, &params) == nil && len(params) == 0) {
+						// celo nodes take the fee currency as the optional param
+						msg.Params, _ = json.Marshal([]interface{}{currency})
+					}
+				}
+				// eth_maxPriorityFeePerGas is answered by celoPriorityFee if the node doesn't have it, with or without currency
+				calls[string(msg.ID)] = msg
+			case MethodEthFeeHistory:
+				calls[string(msg.ID)] = msg
 			}
-			if result.GasLimit == nil {
-				result.GasLimit = new(hexutil.Uint64)
+		}
+		if len(calls) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		serveRewritten(w, req, next, msgs, batch, func(resp *jsonrpcMessage) bool {
+			call, ok := calls[string(resp.ID)]
+			if !ok {
+				return false
 			}
-			msg.Result, err = json.Marshal(result)
-			if err != nil {
-				return err
+			var result json.RawMessage
+			switch {
+			case call.Method == MethodEthMaxPriorityFeePerGas && resp.Error != nil:
+				result, err = celoPriorityFee(req, next, currency)
+			case call.Method == MethodEthFeeHistory && resp.Error == nil && len(resp.Result) > 0 && string(resp.Result) != "null":
+				result, err = normalizeCeloFeeHistory(req, next, currency, call, resp.Result)
+			default:
+				return false
 			}
-			newData, err := json.Marshal(msg)
 			if err != nil {
-				return err
-			}
-			var b bytes.Buffer
-			gz := gzip.NewWriter(&b)
-			if _, err = gz.Write(newData); err != nil {
-				return err
+				log.Warnf("fail to fix %s response, chain:%d, err:%s", call.Method, chainId, redact(err.Error()))
+				return false
 			}
-			if err = gz.Close(); err != nil {
-				return err
-			}
-			resp.Body = ioutil.NopCloser(bytes.NewReader(b.Bytes()))
-			resp.ContentLength = int64(len(b.Bytes()))
+			resp.Result = result
+			resp.Error = nil
+			return true
+		})
+	})
+}
+
+// celoFeeRatio returns the price of CELO in the fee currency, the fees in CELO times it are the fees in the currency
+func celoFeeRatio(req *http.Request, next http.Handler, currency *common.Address) (*big.Rat, error) {
+	if currency == nil {
+		return big.NewRat(1, 1), nil
+	}
+	var prices [2]hexutil.Big
+	for i, params := range [][]interface{}{nil, {currency}} {
+		result, err := callMethod(req, next, MethodEthGasPrice, params...)
+		if err != nil {
+			return nil, err
 		}
-		return nil
+		if err = json.Unmarshal(result, &prices[i]); err != nil {
+			return nil, err
+		}
+	}
+	if prices[0].ToInt().Sign() == 0 {
+		return nil, fmt.Errorf("zero gas price")
+	}
+	return new(big.Rat).SetFrac(prices[1].ToInt(), prices[0].ToInt()), nil
+}
+
+func scaleFee(fee *hexutil.Big, ratio *big.Rat) *hexutil.Big {
+	if fee == nil {
+		return (*hexutil.Big)(new(big.Int))
+	}
+	scaled := new(big.Rat).Mul(new(big.Rat).SetInt(fee.ToInt()), ratio)
+	return (*hexutil.Big)(new(big.Int).Quo(scaled.Num(), scaled.Denom()))
+}
+
+// celoPriorityFee answers eth_maxPriorityFeePerGas for the nodes without it, the tip is the gas price over
+// the base fee of the latest block
+func celoPriorityFee(req *http.Request, next http.Handler, currency *common.Address) (json.RawMessage, error) {
+	var params []interface{}
+	if currency != nil {
+		params = append(params, currency)
+	}
+	result, err := callMethod(req, next, MethodEthGasPrice, params...)
+	if err != nil {
+		return nil, err
+	}
+	var gasPrice hexutil.Big
+	if err = json.Unmarshal(result, &gasPrice); err != nil {
+		return nil, err
+	}
+	if result, err = callMethod(req, next, MethodEthGetBlockByNumber, "latest", false); err != nil {
+		return nil, err
+	}
+	var block struct {
+		BaseFee *hexutil.Big `json:"baseFeePerGas"`
+	}
+	if err = json.Unmarshal(result, &block); err != nil {
+		return nil, err
+	}
+	ratio, err := celoFeeRatio(req, next, currency)
+	if err != nil {
+		return nil, err
+	}
+	tip := new(big.Int).Sub(gasPrice.ToInt(), scaleFee(block.BaseFee, ratio).ToInt())
+	if tip.Sign() < 0 {
+		tip.SetInt64(0)
 	}
+	return json.Marshal((*hexutil.Big)(tip))
 }
 
-// from eth client
-type Header struct {
-	ParentHash  *common.Hash      `json:"parentHash"       gencodec:"required"`
-	UncleHash   *common.Hash      `json:"sha3Uncles"       gencodec:"required"`
-	Coinbase    *common.Address   `json:"miner"            gencodec:"required"`
-	Root        *common.Hash      `json:"stateRoot"        gencodec:"required"`
-	TxHash      *common.Hash      `json:"transactionsRoot" gencodec:"required"`
-	ReceiptHash *common.Hash      `json:"receiptsRoot"     gencodec:"required"`
-	Bloom       *types.Bloom      `json:"logsBloom"        gencodec:"required"`
-	Difficulty  *hexutil.Big      `json:"difficulty"       gencodec:"required"`
-	Number      *hexutil.Big      `json:"number"           gencodec:"required"`
-	GasLimit    *hexutil.Uint64   `json:"gasLimit"         gencodec:"required"`
-	GasUsed     *hexutil.Uint64   `json:"gasUsed"          gencodec:"required"`
-	Time        *hexutil.Uint64   `json:"timestamp"        gencodec:"required"`
-	Extra       *hexutil.Bytes    `json:"extraData"        gencodec:"required"`
-	MixDigest   *common.Hash      `json:"mixHash"`
-	Nonce       *types.BlockNonce `json:"nonce"`
-	BaseFee     *hexutil.Big      `json:"baseFeePerGas" rlp:"optional"`
+// normalizeCeloFeeHistory fills the fields left out, i.e. the base fee after the newest block, the rewards of
+// the requested percentiles, and converts the fees to the fee currency
+func normalizeCeloFeeHistory(req *http.Request, next http.Handler, currency *common.Address, call *jsonrpcMessage, result json.RawMessage) (json.RawMessage, error) {
+	var history celoFeeHistory
+	if err := json.Unmarshal(result, &history); err != nil {
+		return nil, err
+	}
+	if n, ok := decimalQuantity(history.OldestBlock); ok {
+		history.OldestBlock, _ = json.Marshal(hexutil.Uint64(n))
+	}
+	blocks := len(history.GasUsedRatio)
+	if blocks == 0 {
+		blocks = len(history.BaseFee)
+		history.GasUsedRatio = make([]float64, blocks)
+	}
+	// the base fee of the block after the newest one is the last
+	for len(history.BaseFee) > 0 && len(history.BaseFee) < blocks+1 {
+		history.BaseFee = append(history.BaseFee, history.BaseFee[len(history.BaseFee)-1])
+	}
+	var params []json.RawMessage
+	var percentiles []float64
+	if json.Unmarshal(call.Params, &params) == nil && len(params) > 2 {
+		json.Unmarshal(params[2], &percentiles)
+	}
+	if len(percentiles) > 0 && len(history.Reward) < blocks {
+		for len(history.Reward) < blocks {
+			history.Reward = append(history.Reward, nil)
+		}
+	}
+	for i, rewards := range history.Reward {
+		for len(rewards) < len(percentiles) {
+			rewards = append(rewards, nil)
+		}
+		history.Reward[i] = rewards
+	}
+	ratio, err := celoFeeRatio(req, next, currency)
+	if err != nil {
+		return nil, err
+	}
+	for i, fee := range history.BaseFee {
+		history.BaseFee[i] = scaleFee(fee, ratio)
+	}
+	for _, rewards := range history.Reward {
+		for i, reward := range rewards {
+			rewards[i] = scaleFee(reward, ratio)
+		}
+	}
+	return json.Marshal(history)
 }
@@ -17,11 +17,11 @@ type CloverProxy struct {
 }
 
 // NewProxy takes target host and creates a reverse proxy
-func (h *CloverProxy) startCloverProxy(targetHost string, port int, chainId uint64) error {
+func (h *CloverProxy) newCloverProxy(targetHost string) (*httputil.ReverseProxy, error) {
 	var err error
 	h.cloverTargetUrl, err = url.Parse(targetHost)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	p := httputil.NewSingleHostReverseProxy(h.cloverTargetUrl)
 	originalDirector := p.Director
@@ -29,10 +29,7 @@ func (h *CloverProxy) startCloverProxy(targetHost string, port int, chainId uint
 		originalDirector(req)
 		h.modifyCloverRequest(req)
 	}
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", proxyRequestHandler(p))
-	go startCustomProxyByPort(port, mux, chainId, targetHost)
-	return nil
+	return p, nil
 }
 
 func (h *CloverProxy) modifyCloverRequest(req *http.Request) {
@@ -47,6 +44,8 @@ func (h *CloverProxy) modifyCloverRequest(req *http.Request) {
 	msg := &jsonrpcMessage{}
 	if err = json.Unmarshal(reqStr, msg); err != nil {
 		log.Errorf("fail to unmarshal this clover req body err:%s", err.Error())
+		// e.g. batch request, forward it as it is
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqStr))
 		return
 	}
 	if msg.Method == MethodEthGetCode {
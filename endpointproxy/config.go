@@ -0,0 +1,125 @@
+package endpointproxy
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	unixAddrPrefix = "unix:"
+)
+
+// ProxyConfig holds the settings of a proxy server started by StartProxyWithConfig, it can be loaded from a json file
+type ProxyConfig struct {
+	// ListenAddr is host:port, or unix:{socket path} for a unix domain socket
+	ListenAddr string `json:"listen_addr"`
+	// Port is used with 127.0.0.1 if ListenAddr is empty, 0 is a port chosen by the system
+	Port int `json:"port"`
+	// Listener is a pre-opened listener, it takes precedence over ListenAddr
+	Listener net.Listener `json:"-"`
+	// TLS is optional, the listener serves plain http if it is nil
+	TLS *TLSConfig `json:"tls"`
+	// Auth is optional, all the callers are accepted if it is nil
+	Auth *AuthConfig `json:"auth"`
+	// RateLimit is optional, there is no limit if it is nil
+	RateLimit *RateLimitConfig `json:"rate_limit"`
+	// Firewall is optional, all the methods are forwarded if it is nil
+	Firewall *FirewallConfig `json:"firewall"`
+	// Limits is optional, only the default body size limit applies if it is nil
+	Limits *LimitConfig `json:"limits"`
+	// Cors is optional, no CORS header is sent if it is nil
+	Cors *CorsConfig `json:"cors"`
+	// Upstream is optional, it sets the credentials of the requests to the upstream
+	Upstream *UpstreamConfig `json:"upstream"`
+	// Cache is optional, no response is cached if it is nil
+	Cache *CacheConfig `json:"cache"`
+	// GetLogs is optional, the eth_getLogs calls are only split for the chains with a built-in max range if it is nil
+	GetLogs *GetLogsConfig `json:"get_logs"`
+	// Normalize is optional, the built-in policies of celo, conflux and zksync apply if it is nil
+	Normalize *NormalizeConfig `json:"normalize"`
+	// Fixup is optional, only the built-in rules of the chain apply if it is nil
+	Fixup *FixupConfig `json:"fixup"`
+	// BlockHashCheck is optional, the hashes of the returned blocks are not checked if it is nil
+	BlockHashCheck *BlockHashCheckConfig `json:"block_hash_check"`
+	// Celo is optional, the fees of the celo proxies are in CELO if it is nil
+	Celo *CeloConfig `json:"celo"`
+	// Harmony is optional, all the requests of the harmony proxies go to their endpoint if it is nil
+	Harmony *HarmonyConfig `json:"harmony"`
+}
+
+// LoadProxyConfig reads the ProxyConfig from a json file
+func LoadProxyConfig(path string) (*ProxyConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(ProxyConfig)
+	if err = json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid proxy config %s, err:%w", path, err)
+	}
+	if err = cfg.RateLimit.validate(); err != nil {
+		return nil, fmt.Errorf("invalid proxy config %s, err:%w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *ProxyConfig) listenAddr() string {
+	if c.Listener != nil {
+		return c.Listener.Addr().String()
+	}
+	if c.ListenAddr == "" {
+		return fmt.Sprintf("127.0.0.1:%d", c.Port)
+	}
+	return c.ListenAddr
+}
+
+func (c *ProxyConfig) listen() (net.Listener, error) {
+	var reloader *tlsReloader
+	if c.TLS != nil {
+		var err error
+		if reloader, err = newTLSReloader(c.TLS); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := c.rawListen()
+	if err != nil {
+		return nil, err
+	}
+	if reloader != nil {
+		ln = tls.NewListener(ln, reloader.tlsConfig())
+	}
+	return ln, nil
+}
+
+func (c *ProxyConfig) rawListen() (net.Listener, error) {
+	if c.Listener != nil {
+		return c.Listener, nil
+	}
+	if strings.HasPrefix(c.ListenAddr, unixAddrPrefix) {
+		path := strings.TrimPrefix(c.ListenAddr, unixAddrPrefix)
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", c.listenAddr())
+}
+
+// removeStaleSocket removes the socket file left by a previous process, it fails if the socket is still in use
+func removeStaleSocket(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	if conn, dialErr := net.DialTimeout("unix", path, time.Second); dialErr == nil {
+		conn.Close()
+		return fmt.Errorf("unix socket %s is in use", path)
+	}
+	return os.Remove(path)
+}
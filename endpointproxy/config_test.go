@@ -0,0 +1,39 @@
+package endpointproxy
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProxyConfigListenAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *ProxyConfig
+		want string
+	}{
+		{"empty", &ProxyConfig{}, "127.0.0.1:0"},
+		{"port", &ProxyConfig{Port: 10090}, "127.0.0.1:10090"},
+		{"addr", &ProxyConfig{ListenAddr: "0.0.0.0:10090", Port: 10091}, "0.0.0.0:10090"},
+		{"unix", &ProxyConfig{ListenAddr: "unix:/tmp/proxy.sock"}, "unix:/tmp/proxy.sock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if addr := tt.cfg.listenAddr(); addr != tt.want {
+				t.Errorf("listenAddr() = %s, want %s", addr, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartProxyWithNilConfig(t *testing.T) {
+	if err := StartProxyWithConfig("http://127.0.0.1:1", harmonyTestnetChainId, nil); err != nil {
+		t.Fatal(err)
+	}
+	chainIdSvrLock.Lock()
+	svrWrap := chainIdSvrMap[harmonyTestnetChainId]
+	chainIdSvrLock.Unlock()
+	defer svrWrap.Svr.Shutdown(context.Background())
+	if svrWrap.Port == 0 || svrWrap.Addr != "127.0.0.1:0" {
+		t.Errorf("server on port %d, addr %s, want a port of 127.0.0.1", svrWrap.Port, svrWrap.Addr)
+	}
+}
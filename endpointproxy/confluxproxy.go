@@ -7,9 +7,9 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strings"
 
 	"github.com/celer-network/goutils/log"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 type ConfluxProxy struct {
@@ -17,11 +17,11 @@ type ConfluxProxy struct {
 }
 
 // NewProxy takes target host and creates a reverse proxy
-func (h *ConfluxProxy) startConfluxProxy(targetHost string, port int, chainId uint64) error {
+func (h *ConfluxProxy) newConfluxProxy(targetHost string) (*httputil.ReverseProxy, error) {
 	var err error
 	h.confluxTargetUrl, err = url.Parse(targetHost)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	p := httputil.NewSingleHostReverseProxy(h.confluxTargetUrl)
 	originalDirector := p.Director
@@ -29,10 +29,7 @@ func (h *ConfluxProxy) startConfluxProxy(targetHost string, port int, chainId ui
 		originalDirector(req)
 		h.modifyConfluxRequest(req)
 	}
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", proxyRequestHandler(p))
-	go startCustomProxyByPort(port, mux, chainId, targetHost)
-	return nil
+	return p, nil
 }
 
 func (h *ConfluxProxy) modifyConfluxRequest(req *http.Request) {
@@ -44,24 +41,104 @@ func (h *ConfluxProxy) modifyConfluxRequest(req *http.Request) {
 		log.Errorf("invalid conflux request err:%s", err.Error())
 		return
 	}
-	msg := &jsonrpcMessage{}
-	if err = json.Unmarshal(reqStr, msg); err != nil {
+	msgs, batch, err := parseJsonRpcBody(reqStr)
+	if err != nil {
 		log.Errorf("fail to unmarshal this conflux req body err:%s", err.Error())
+		// forward it as it is, the upstream reports the error
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqStr))
 		return
 	}
-	if msg.Method == MethodEthGetCode {
-		newParams := strings.Replace(string(msg.Params), "\"pending\"", "\"latest\"", 1)
-		msg.Params = []byte(newParams)
+	for _, msg := range msgs {
+		fixConfluxParams(msg)
 	}
-	if msg.Method == MethodEthCall {
-		newParams := strings.Replace(string(msg.Params), ",\"from\":\"0x0000000000000000000000000000000000000000\"", "", 1)
-		msg.Params = []byte(newParams)
+	var newMsg []byte
+	if batch {
+		newMsg, err = json.Marshal(msgs)
+	} else {
+		newMsg, err = json.Marshal(msgs[0])
 	}
-	newMsg, marshalErr := json.Marshal(msg)
-	if marshalErr != nil {
-		log.Errorf("fail to marshal this new conflux req, raw:%s, err:%s", string(newMsg), marshalErr.Error())
+	if err != nil {
+		log.Errorf("fail to marshal this new conflux req, raw:%s, err:%s", string(reqStr), err.Error())
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqStr))
 		return
 	}
 	req.Body = ioutil.NopCloser(bytes.NewReader(newMsg))
 	req.ContentLength = int64(len(newMsg))
 }
+
+var (
+	// the methods with a call object as the first param, eSpace rejects the zero from address in it
+	confluxCallMethods = map[string]bool{
+		MethodEthCall:             true,
+		MethodEthEstimateGas:      true,
+		MethodEthCreateAccessList: true,
+	}
+	// the index of the block param of the state methods, eSpace runs them on the latest state and rejects the tags
+	// of confluxBlockTags
+	confluxBlockParamIndex = map[string]int{
+		MethodEthGetCode:          1,
+		MethodEthCall:             1,
+		MethodEthEstimateGas:      1,
+		MethodEthCreateAccessList: 1,
+		MethodEthGetBalance:       1,
+		MethodEthGetStorageAt:     2,
+	}
+	// the block tags mapped to the tags eSpace accepts for the state methods, the core space epoch tags are mapped
+	// to the eSpace tags of the same epoch
+	confluxBlockTags = map[string]string{
+		"pending":          "latest",
+		"latest_mined":     "latest",
+		"latest_state":     "latest",
+		"latest_confirmed": "safe",
+		"latest_finalized": "finalized",
+	}
+	// the call object fields of core space which eSpace rejects
+	confluxCoreCallFields = []string{"storageLimit", "epochHeight"}
+)
+
+// fixConfluxParams rewrites the params of the call in place, the params not understood are left as they are
+func fixConfluxParams(msg *jsonrpcMessage) {
+	index, ok := confluxBlockParamIndex[msg.Method]
+	if !ok {
+		return
+	}
+	var params []json.RawMessage
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return
+	}
+	changed := false
+	if confluxCallMethods[msg.Method] && len(params) > 0 {
+		var call jsonObject
+		if json.Unmarshal(params[0], &call) == nil && call != nil {
+			from := call.getString("from")
+			if common.IsHexAddress(from) && common.HexToAddress(from) == (common.Address{}) {
+				delete(call, "from")
+				changed = true
+			}
+			for _, field := range confluxCoreCallFields {
+				if _, ok := call[field]; ok {
+					delete(call, field)
+					changed = true
+				}
+			}
+			if changed {
+				params[0], _ = json.Marshal(call)
+			}
+		}
+	}
+	if len(params) > index {
+		var tag string
+		if json.Unmarshal(params[index], &tag) == nil {
+			if epoch, ok := confluxBlockTags[tag]; ok {
+				params[index], _ = json.Marshal(epoch)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return
+	}
+	if data, err := json.Marshal(params); err == nil {
+		msg.Params = data
+	}
+}
@@ -0,0 +1,38 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFixConfluxParams(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		params     string
+		wantParams string
+	}{
+		{"zero from", MethodEthCall, `[{"from":"0x0000000000000000000000000000000000000000","to":"0x01"},"latest"]`,
+			`[{"to":"0x01"},"latest"]`},
+		{"short zero from", MethodEthCall, `[{"from":"0x0","to":"0x01"},"latest"]`, `[{"from":"0x0","to":"0x01"},"latest"]`},
+		{"invalid from", MethodEthEstimateGas, `[{"from":"abc","to":"0x01"}]`, `[{"from":"abc","to":"0x01"}]`},
+		{"from", MethodEthCall, `[{"from":"0x0000000000000000000000000000000000000001"},"latest"]`,
+			`[{"from":"0x0000000000000000000000000000000000000001"},"latest"]`},
+		{"core fields", MethodEthCall, `[{"to":"0x01","storageLimit":"0x1","epochHeight":"0x1"},"latest"]`, `[{"to":"0x01"},"latest"]`},
+		{"pending", MethodEthGetBalance, `["0x01","pending"]`, `["0x01","latest"]`},
+		{"latest state", MethodEthGetBalance, `["0x01","latest_state"]`, `["0x01","latest"]`},
+		{"latest confirmed", MethodEthGetCode, `["0x01","latest_confirmed"]`, `["0x01","safe"]`},
+		{"latest finalized", MethodEthGetStorageAt, `["0x01","0x0","latest_finalized"]`, `["0x01","0x0","finalized"]`},
+		{"number", MethodEthGetBalance, `["0x01","0x10"]`, `["0x01","0x10"]`},
+		{"other method", MethodEthGetLogs, `[{"fromBlock":"pending"}]`, `[{"fromBlock":"pending"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &jsonrpcMessage{Method: tt.method, Params: json.RawMessage(tt.params)}
+			fixConfluxParams(msg)
+			if string(msg.Params) != tt.wantParams {
+				t.Errorf("params = %s, want %s", msg.Params, tt.wantParams)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package endpointproxy
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var defaultCorsHeaders = []string{"Content-Type", "Authorization"}
+
+// CorsConfig enables CORS for browser dApps, preflight requests are answered by the proxy
+type CorsConfig struct {
+	// AllowedOrigins supports "*" and wildcards like "https://*.example.com"
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedHeaders is the request headers allowed, default is Content-Type and Authorization
+	AllowedHeaders []string `json:"allowed_headers"`
+	// MaxAge is the seconds the preflight result can be cached by browsers, not sent if it is 0
+	MaxAge int `json:"max_age"`
+}
+
+func (c *CorsConfig) allowedOrigin(origin string) bool {
+	for _, pattern := range c.AllowedOrigins {
+		if pattern == "*" {
+			return true
+		}
+		if ok, err := path.Match(pattern, origin); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// corsHandler answers preflight requests and sets the CORS headers of the allowed origins
+func corsHandler(cfg *CorsConfig, p *httputil.ReverseProxy, next http.Handler) http.Handler {
+	allowedHeaders := cfg.AllowedHeaders
+	if len(allowedHeaders) == 0 {
+		allowedHeaders = defaultCorsHeaders
+	}
+	// the CORS headers are set by the proxy, the ones from upstream would be duplicated
+	modifyResponse := p.ModifyResponse
+	p.ModifyResponse = func(resp *http.Response) error {
+		for k := range resp.Header {
+			if strings.HasPrefix(k, "Access-Control-") {
+				resp.Header.Del(k)
+			}
+		}
+		if modifyResponse != nil {
+			return modifyResponse(resp)
+		}
+		return nil
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		preflight := req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" || !cfg.allowedOrigin(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if !preflight {
+			next.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(allowedHeaders, ", "))
+		if cfg.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
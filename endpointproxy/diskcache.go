@@ -0,0 +1,210 @@
+package endpointproxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/celer-network/goutils/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultDiskCacheMaxBytes = 1 << 30
+	// eviction frees space down to this percentage of MaxBytes, so that it does not run on every write
+	diskCacheEvictPercent = 90
+
+	diskCacheWriteQueue = 4096
+	diskCacheMaxBatch   = 1024
+)
+
+var (
+	resultsBucket = []byte("results")
+	orderBucket   = []byte("order")
+	metaBucket    = []byte("meta")
+	sizeKey       = []byte("size")
+
+	diskStoresLock sync.Mutex
+	diskStores     = make(map[string]*diskStore)
+)
+
+// DiskCacheConfig keeps the immutable results in an embedded bbolt database, so that they survive restarts.
+// The results are kept in memory as well, the disk is only read on memory misses.
+type DiskCacheConfig struct {
+	// Path is the database file, it can be shared by the proxies of different chains in one process
+	Path string `json:"path"`
+	// MaxBytes caps the size of the cached results, the earliest cached ones are evicted first, default is 1GB.
+	// The database file does not shrink after eviction, the freed pages are reused.
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+type diskEntry struct {
+	key    string
+	result json.RawMessage
+	// the keys to delete instead, e.g. responses of reorged blocks
+	removed []string
+}
+
+// diskStore is a size capped store of results, writes are queued and committed in batches
+type diskStore struct {
+	path     string
+	maxBytes int64
+	db       *bolt.DB
+	pending  chan *diskEntry
+}
+
+// openDiskStore opens the store of cfg, the store of a path is opened once and shared
+func openDiskStore(cfg *DiskCacheConfig) (*diskStore, error) {
+	diskStoresLock.Lock()
+	defer diskStoresLock.Unlock()
+	if s, ok := diskStores[cfg.Path]; ok {
+		return s, nil
+	}
+	maxBytes := cfg.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultDiskCacheMaxBytes
+	}
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("fail to open disk cache %s, err:%w", cfg.Path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{resultsBucket, orderBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("fail to init disk cache %s, err:%w", cfg.Path, err)
+	}
+	s := &diskStore{path: cfg.Path, maxBytes: maxBytes, db: db, pending: make(chan *diskEntry, diskCacheWriteQueue)}
+	go s.writeLoop()
+	diskStores[cfg.Path] = s
+	return s, nil
+}
+
+func (s *diskStore) get(key string) (json.RawMessage, bool) {
+	var result json.RawMessage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// the value is the 8 bytes order sequence followed by the result
+		if v := tx.Bucket(resultsBucket).Get([]byte(key)); len(v) > 8 {
+			result = append(json.RawMessage(nil), v[8:]...)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("fail to read disk cache %s, err:%s", s.path, err.Error())
+		return nil, false
+	}
+	return result, result != nil
+}
+
+// add queues the result to be written, it is dropped if the queue is full
+func (s *diskStore) add(key string, result json.RawMessage) {
+	select {
+	case s.pending <- &diskEntry{key: key, result: result}:
+	default:
+		incCounter(1, "cache", "disk", "dropped")
+	}
+}
+
+// remove queues the keys to be deleted, after the queued writes
+func (s *diskStore) remove(keys []string) {
+	s.pending <- &diskEntry{removed: keys}
+}
+
+func (s *diskStore) writeLoop() {
+	for entry := range s.pending {
+		entries := []*diskEntry{entry}
+	collect:
+		for len(entries) < diskCacheMaxBatch {
+			select {
+			case entry = <-s.pending:
+				entries = append(entries, entry)
+			default:
+				break collect
+			}
+		}
+		if err := s.db.Update(func(tx *bolt.Tx) error { return s.write(tx, entries) }); err != nil {
+			log.Warnf("fail to write disk cache %s, err:%s", s.path, err.Error())
+		}
+	}
+}
+
+func (s *diskStore) write(tx *bolt.Tx, entries []*diskEntry) error {
+	results := tx.Bucket(resultsBucket)
+	order := tx.Bucket(orderBucket)
+	meta := tx.Bucket(metaBucket)
+	var size int64
+	if v := meta.Get(sizeKey); len(v) == 8 {
+		size = int64(binary.BigEndian.Uint64(v))
+	}
+	for _, entry := range entries {
+		for _, removed := range entry.removed {
+			key := []byte(removed)
+			v := results.Get(key)
+			if len(v) < 8 {
+				continue
+			}
+			size -= int64(len(key) + len(v))
+			if err := order.Delete(v[:8]); err != nil {
+				return err
+			}
+			if err := results.Delete(key); err != nil {
+				return err
+			}
+		}
+		if entry.removed != nil {
+			continue
+		}
+		key := []byte(entry.key)
+		if results.Get(key) != nil {
+			continue
+		}
+		seq, err := order.NextSequence()
+		if err != nil {
+			return err
+		}
+		seqBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(seqBytes, seq)
+		if err = results.Put(key, append(seqBytes, entry.result...)); err != nil {
+			return err
+		}
+		if err = order.Put(seqBytes, key); err != nil {
+			return err
+		}
+		size += int64(len(key) + 8 + len(entry.result))
+	}
+	if size > s.maxBytes {
+		// deleting under a cursor skips the next key, collect the evicted ones first
+		var seqs [][]byte
+		target := s.maxBytes * diskCacheEvictPercent / 100
+		c := order.Cursor()
+		for seq, key := c.First(); seq != nil && size > target; seq, key = c.Next() {
+			if v := results.Get(key); v != nil {
+				size -= int64(len(key) + len(v))
+				if err := results.Delete(key); err != nil {
+					return err
+				}
+			}
+			seqs = append(seqs, seq)
+		}
+		for _, seq := range seqs {
+			if err := order.Delete(seq); err != nil {
+				return err
+			}
+		}
+		incCounter(int64(len(seqs)), "cache", "disk", "evicted")
+	}
+	if size < 0 {
+		size = 0
+	}
+	sizeBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(sizeBytes, uint64(size))
+	return meta.Put(sizeKey, sizeBytes)
+}
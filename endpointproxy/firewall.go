@@ -0,0 +1,149 @@
+package endpointproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/celer-network/goutils/log"
+)
+
+const (
+	errCodeMethodNotFound = -32601
+)
+
+// MethodRules are json rpc method patterns, "*" and "?" wildcards are supported, e.g. "debug_*"
+type MethodRules struct {
+	// Allow is optional, if it is not empty only the methods matching one of the patterns are forwarded
+	Allow []string `json:"allow"`
+	// Deny takes precedence over Allow
+	Deny []string `json:"deny"`
+}
+
+// FirewallConfig blocks json rpc methods before forwarding, each call in a batch is checked
+type FirewallConfig struct {
+	MethodRules
+	// Chains replaces the rules above for the chain ids in it
+	Chains map[uint64]*MethodRules `json:"chains"`
+}
+
+func (c *FirewallConfig) rules(chainId uint64) *MethodRules {
+	if rules, ok := c.Chains[chainId]; ok {
+		return rules
+	}
+	return &c.MethodRules
+}
+
+func (r *MethodRules) enabled() bool {
+	return len(r.Allow) > 0 || len(r.Deny) > 0
+}
+
+func (r *MethodRules) allowed(method string) bool {
+	if matchMethod(r.Deny, method) {
+		return false
+	}
+	return len(r.Allow) == 0 || matchMethod(r.Allow, method)
+}
+
+func matchMethod(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, method); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func methodNotFound(msg *jsonrpcMessage) *jsonrpcMessage {
+	return newJsonRpcError(msg.ID, errCodeMethodNotFound, fmt.Sprintf("the method %s does not exist/is not available", msg.Method), nil)
+}
+
+// filter splits the calls into the allowed and the blocked ones
+func (r *MethodRules) filter(req *http.Request, chainId uint64, msgs []*jsonrpcMessage) (allowed, blocked []*jsonrpcMessage) {
+	for _, msg := range msgs {
+		if r.allowed(msg.Method) {
+			allowed = append(allowed, msg)
+		} else {
+			log.Warnf("block method %s, chain:%d, client:%s", msg.Method, chainId, clientIdentity(req))
+			incCounter(1, "firewall", "blocked")
+			blocked = append(blocked, msg)
+		}
+	}
+	return allowed, blocked
+}
+
+// firewallHandler answers the blocked calls with method not found error, only the allowed calls of a batch are forwarded.
+// The requests which can't be parsed are rejected, as the calls in them can't be checked
+func firewallHandler(rules *MethodRules, chainId uint64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isWebsocketUpgrade(req) {
+			next.ServeHTTP(interceptWebsocket(w, req, firewallWebsocket(rules, chainId, req), nil), req)
+			return
+		}
+		body, err := readReqBody(req)
+		if err != nil {
+			writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, "invalid request", nil)
+			return
+		}
+		if len(bytes.TrimSpace(body)) == 0 {
+			// no call in it, e.g. health checks
+			next.ServeHTTP(w, req)
+			return
+		}
+		msgs, batch, err := parseJsonRpcBody(body)
+		if err != nil {
+			writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, "invalid request", nil)
+			return
+		}
+		allowed, blocked := rules.filter(req, chainId, msgs)
+		if len(blocked) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		var blockedResps []*jsonrpcMessage
+		for _, msg := range blocked {
+			blockedResps = append(blockedResps, methodNotFound(msg))
+		}
+		serveWithAnswered(w, req, next, batch, allowed, blockedResps)
+	})
+}
+
+// firewallWebsocket checks the messages of a websocket like firewallHandler, the blocked calls are answered on the
+// websocket in one message and the message with the allowed calls is forwarded
+func firewallWebsocket(rules *MethodRules, chainId uint64, req *http.Request) wsMessageHook {
+	return func(msg []byte, reply func([]byte)) []byte {
+		msgs, batch, err := parseJsonRpcBody(msg)
+		if err != nil {
+			resp, _ := json.Marshal(newJsonRpcError(nil, errCodeInvalidRequest, "invalid request", nil))
+			reply(resp)
+			return nil
+		}
+		allowed, blocked := rules.filter(req, chainId, msgs)
+		if len(blocked) == 0 {
+			return msg
+		}
+		if !batch {
+			resp, _ := json.Marshal(methodNotFound(blocked[0]))
+			reply(resp)
+			return nil
+		}
+		var blockedResps []*jsonrpcMessage
+		for _, msg := range blocked {
+			// notifications have no response
+			if len(msg.ID) > 0 && string(msg.ID) != "null" {
+				blockedResps = append(blockedResps, methodNotFound(msg))
+			}
+		}
+		if len(blockedResps) > 0 {
+			resp, _ := json.Marshal(blockedResps)
+			reply(resp)
+		}
+		if len(allowed) == 0 {
+			return nil
+		}
+		forwarded, _ := json.Marshal(allowed)
+		return forwarded
+	}
+}
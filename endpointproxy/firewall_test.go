@@ -0,0 +1,151 @@
+package endpointproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFirewall(t *testing.T) {
+	rules := &MethodRules{Deny: []string{"admin_*"}}
+	tests := []struct {
+		name          string
+		body          string
+		wantForwarded string
+		wantCodes     []int
+	}{
+		{"allowed", `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`,
+			`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`, nil},
+		{"denied", `{"jsonrpc":"2.0","id":1,"method":"admin_addPeer","params":[]}`, "", []int{errCodeMethodNotFound}},
+		{"denied in batch", `[{"jsonrpc":"2.0","id":1,"method":"admin_addPeer","params":[]},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}]`,
+			`[{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}]`, []int{0, errCodeMethodNotFound}},
+		{"invalid version", `{"jsonrpc":2,"id":1,"method":"admin_addPeer","params":[]}`, "", []int{errCodeInvalidRequest}},
+		{"invalid batch element", `[{"jsonrpc":"2.0","id":1,"method":"admin_addPeer","params":[]},1]`, "", []int{errCodeInvalidRequest}},
+		{"not json", `admin_addPeer`, "", []int{errCodeInvalidRequest}},
+		{"empty", ``, ``, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded := "not forwarded"
+			next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				body, _ := readReqBody(req)
+				forwarded = string(body)
+				msgs, batch, err := parseJsonRpcBody(body)
+				if err != nil {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				var resps []*jsonrpcMessage
+				for _, msg := range msgs {
+					resps = append(resps, &jsonrpcMessage{Version: "2.0", ID: msg.ID, Result: json.RawMessage(`"0x1"`)})
+				}
+				if batch {
+					writeJsonRpcResponse(w, http.StatusOK, resps)
+				} else {
+					writeJsonRpcResponse(w, http.StatusOK, resps[0])
+				}
+			})
+			rec := httptest.NewRecorder()
+			firewallHandler(rules, 1, next).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+			if tt.wantForwarded == "" && tt.body != "" {
+				if forwarded != "not forwarded" {
+					t.Fatalf("forwarded %s", forwarded)
+				}
+			} else if forwarded != tt.wantForwarded {
+				t.Fatalf("forwarded %s, want %s", forwarded, tt.wantForwarded)
+			}
+			if tt.wantCodes == nil {
+				return
+			}
+			var resps []*jsonrpcMessage
+			if strings.HasPrefix(tt.body, "[") && tt.wantCodes[0] != errCodeInvalidRequest {
+				if err := json.Unmarshal(rec.Body.Bytes(), &resps); err != nil {
+					t.Fatalf("invalid response %s: %v", rec.Body.String(), err)
+				}
+			} else {
+				resp := new(jsonrpcMessage)
+				if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+					t.Fatalf("invalid response %s: %v", rec.Body.String(), err)
+				}
+				resps = append(resps, resp)
+			}
+			if len(resps) != len(tt.wantCodes) {
+				t.Fatalf("got %d responses, want %d: %s", len(resps), len(tt.wantCodes), rec.Body.String())
+			}
+			for i, resp := range resps {
+				code := 0
+				if resp.Error != nil {
+					code = resp.Error.Code
+				}
+				if code != tt.wantCodes[i] {
+					t.Errorf("response %d code = %d, want %d", i, code, tt.wantCodes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFirewallWebsocket(t *testing.T) {
+	allowed := `{"jsonrpc":"2.0","id":3,"method":"eth_chainId"}`
+	upstream := wsUpstream(t, allowed, `{"jsonrpc":"2.0","id":3,"result":"0x1"}`,
+		`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0x9","result":"0x1"}}`)
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	rules := &MethodRules{Deny: []string{"admin_*"}}
+	proxy := httptest.NewServer(firewallHandler(rules, 1, httputil.NewSingleHostReverseProxy(target)))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: proxy\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want 101", resp.StatusCode)
+	}
+	var frames []byte
+	for _, msg := range []string{
+		`{"jsonrpc":2,"id":1,"method":"admin_addPeer","params":[]}`,
+		`{"jsonrpc":"2.0","id":2,"method":"admin_addPeer","params":[]}`,
+		allowed,
+	} {
+		frames = append(frames, encodeWsFrame(wsOpText, []byte(msg), true)...)
+	}
+	conn.Write(frames)
+	messages := &wsReader{r: r}
+	for _, want := range []struct {
+		id   string
+		code int
+	}{{"null", errCodeInvalidRequest}, {"2", errCodeMethodNotFound}, {"3", 0}} {
+		msg, err := messages.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp jsonrpcMessage
+		if err = json.Unmarshal(msg, &resp); err != nil {
+			t.Fatalf("invalid response %s: %v", msg, err)
+		}
+		code := 0
+		if resp.Error != nil {
+			code = resp.Error.Code
+		}
+		if string(resp.ID) != want.id || code != want.code {
+			t.Errorf("got %s, want id %s and code %d", msg, want.id, want.code)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const (
+	// FixupIfMissing matches the absent and null fields
+	FixupIfMissing = "missing"
+	// FixupIfEquals matches the fields equal to FixupRule.Equals
+	FixupIfEquals = "equals"
+	// FixupIfAlways matches the fields whatever the value, including the absent ones
+	FixupIfAlways = "always"
+)
+
+// FixupRule sets a field of the results of the matched methods, e.g. the stateRoot "0x" of ontology blocks
+// to the zero hash
+type FixupRule struct {
+	// Methods are the json rpc method patterns the rule applies to, "*" and "?" wildcards are supported
+	Methods []string `json:"methods"`
+	// Path is the dot separated path of the field in the result, "*" matches all the elements of an array,
+	// e.g. "stateRoot" or "transactions.*.v"
+	Path string `json:"path"`
+	// If is the condition of the field, one of missing, equals and always, default is missing
+	If string `json:"if"`
+	// Equals is the value compared with the field if the condition is equals
+	Equals json.RawMessage `json:"equals"`
+	// Set is the new value of the field
+	Set json.RawMessage `json:"set"`
+}
+
+// FixupConfig adds fixup rules, the rules are applied in order after the built-in ones of the chain
+type FixupConfig struct {
+	// Rules apply to all the chains
+	Rules []*FixupRule `json:"rules"`
+	// Chains replaces the built-in rules for the chain ids in it, an empty list removes them
+	Chains map[uint64][]*FixupRule `json:"chains"`
+}
+
+var (
+	zeroHashJson  = json.RawMessage(`"0x0000000000000000000000000000000000000000000000000000000000000000"`)
+	zeroBloomJson = json.RawMessage(`"0x` + strings.Repeat("0", 512) + `"`)
+	blockMethods  = []string{MethodEthGetBlockByNumber, MethodEthGetBlockByHash}
+	// the header fields eth client requires which some chains leave out
+	powHeaderFixups = []*FixupRule{
+		{Methods: blockMethods, Path: "sha3Uncles", Set: json.RawMessage(`"0x1dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347"`)},
+		{Methods: blockMethods, Path: "difficulty", Set: json.RawMessage(`"0x0"`)},
+		{Methods: blockMethods, Path: "gasLimit", Set: json.RawMessage(`"0x0"`)},
+	}
+	zkSyncHeaderFixups = []*FixupRule{
+		{Methods: blockMethods, Path: "logsBloom", Set: zeroBloomJson},
+	}
+	defaultFixupRules = map[uint64][]*FixupRule{
+		ontologyChainId: {
+			{Methods: blockMethods, Path: "stateRoot", If: FixupIfEquals, Equals: json.RawMessage(`"0x"`), Set: zeroHashJson},
+		},
+		platonChainId:        powHeaderFixups,
+		celoChainId:          powHeaderFixups,
+		celoTestnetChainId:   powHeaderFixups,
+		zkSyncTestnetChainId: zkSyncHeaderFixups,
+		zkSyncMainnetChainId: zkSyncHeaderFixups,
+	}
+)
+
+// rules returns the rules of the chain, the config may be nil
+func (c *FixupConfig) rules(chainId uint64) ([]*FixupRule, error) {
+	rules := defaultFixupRules[chainId]
+	if c != nil {
+		if chainRules, ok := c.Chains[chainId]; ok {
+			rules = chainRules
+		}
+		rules = append(append([]*FixupRule{}, rules...), c.Rules...)
+	}
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+func (r *FixupRule) validate() error {
+	if len(r.Methods) == 0 || r.Path == "" {
+		return fmt.Errorf("invalid fixup rule %s, methods and path are required", r.Path)
+	}
+	switch r.If {
+	case "", FixupIfMissing, FixupIfAlways:
+	case FixupIfEquals:
+		if !json.Valid(r.Equals) {
+			return fmt.Errorf("invalid fixup rule %s, equals is not json", r.Path)
+		}
+	default:
+		return fmt.Errorf("invalid fixup rule %s, unknown condition %s", r.Path, r.If)
+	}
+	if !json.Valid(r.Set) {
+		return fmt.Errorf("invalid fixup rule %s, set is not json", r.Path)
+	}
+	return nil
+}
+
+func (r *FixupRule) matches(v json.RawMessage, ok bool) bool {
+	switch r.If {
+	case FixupIfAlways:
+		return true
+	case FixupIfEquals:
+		if !ok {
+			return false
+		}
+		var a, b interface{}
+		if json.Unmarshal(v, &a) != nil || json.Unmarshal(r.Equals, &b) != nil {
+			return false
+		}
+		return reflect.DeepEqual(a, b)
+	}
+	return !ok || string(v) == "null"
+}
+
+// apply returns the result with the field at path fixed, and whether it is changed,
+// the fields under a missing or non-object parent are not touched
+func (r *FixupRule) apply(v json.RawMessage, path []string) (json.RawMessage, bool) {
+	if path[0] == "*" {
+		var items []json.RawMessage
+		if json.Unmarshal(v, &items) != nil {
+			return v, false
+		}
+		changed := false
+		for i, item := range items {
+			if fixed, ok := r.apply(item, path[1:]); ok {
+				items[i] = fixed
+				changed = true
+			}
+		}
+		if !changed {
+			return v, false
+		}
+		data, err := json.Marshal(items)
+		return data, err == nil
+	}
+	var obj jsonObject
+	if json.Unmarshal(v, &obj) != nil || obj == nil {
+		return v, false
+	}
+	field, ok := obj[path[0]]
+	if len(path) > 1 {
+		if !ok {
+			return v, false
+		}
+		fixed, changed := r.apply(field, path[1:])
+		if !changed {
+			return v, false
+		}
+		obj[path[0]] = fixed
+	} else {
+		if !r.matches(field, ok) {
+			return v, false
+		}
+		obj[path[0]] = r.Set
+	}
+	data, err := json.Marshal(obj)
+	return data, err == nil
+}
+
+// fixupHandler applies the rules to the results of the calls, other responses are not touched
+func fixupHandler(rules []*FixupRule, chainId uint64, next http.Handler) http.Handler {
+	paths := make([][]string, len(rules))
+	for i, rule := range rules {
+		paths[i] = strings.Split(rule.Path, ".")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := readReqBody(req)
+		if err != nil {
+			writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, "invalid request", nil)
+			return
+		}
+		msgs, batch, err := parseJsonRpcBody(body)
+		if err != nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		calls := make(map[string][]int)
+		for _, msg := range msgs {
+			if len(msg.ID) == 0 || string(msg.ID) == "null" {
+				continue
+			}
+			for i, rule := range rules {
+				if matchMethod(rule.Methods, msg.Method) {
+					calls[string(msg.ID)] = append(calls[string(msg.ID)], i)
+				}
+			}
+		}
+		if len(calls) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		serveRewritten(w, req, next, msgs, batch, func(resp *jsonrpcMessage) bool {
+			if resp.Error != nil || len(resp.Result) == 0 || string(resp.Result) == "null" {
+				return false
+			}
+			changed := false
+			for _, i := range calls[string(resp.ID)] {
+				if fixed, ok := rules[i].apply(resp.Result, paths[i]); ok {
+					resp.Result = fixed
+					changed = true
+				}
+			}
+			if changed {
+				incCounter(1, "fixup", "applied")
+			}
+			return changed
+		})
+	})
+}
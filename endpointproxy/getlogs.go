@@ -0,0 +1,245 @@
+package endpointproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/celer-network/goutils/log"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	defaultGetLogsConcurrency = 4
+	defaultGetLogsMaxChunks   = 100
+)
+
+// the max eth_getLogs range of the chains whose upstreams reject larger ones
+var defaultGetLogsMaxRange = map[uint64]uint64{
+	harmonyChainId:        1024,
+	harmonyTestnetChainId: 1024,
+	confluxChainId:        1000,
+}
+
+// GetLogsConfig splits the eth_getLogs calls over the max range of the upstream into chunks, the chunks are
+// sent concurrently and their logs are merged in order into one response. The range is clamped to the head block
+// before splitting
+type GetLogsConfig struct {
+	// MaxRange is the max number of blocks of an upstream eth_getLogs call, 0 means the built-in range of the chain if any
+	MaxRange uint64 `json:"max_range"`
+	// Chains replaces MaxRange for the chain ids in it
+	Chains map[uint64]uint64 `json:"chains"`
+	// Concurrency is the max number of chunks in flight of a call, default is 4
+	Concurrency int `json:"concurrency"`
+	// MaxChunks rejects the calls which need more chunks, default is 100
+	MaxChunks uint64 `json:"max_chunks"`
+}
+
+func (c *GetLogsConfig) maxRange(chainId uint64) uint64 {
+	if c != nil {
+		if maxRange, ok := c.Chains[chainId]; ok {
+			return maxRange
+		}
+		if c.MaxRange > 0 {
+			return c.MaxRange
+		}
+	}
+	return defaultGetLogsMaxRange[chainId]
+}
+
+func (c *GetLogsConfig) concurrency() int {
+	if c == nil || c.Concurrency <= 0 {
+		return defaultGetLogsConcurrency
+	}
+	return c.Concurrency
+}
+
+func (c *GetLogsConfig) maxChunks() uint64 {
+	if c == nil || c.MaxChunks == 0 {
+		return defaultGetLogsMaxChunks
+	}
+	return c.MaxChunks
+}
+
+// getLogsSplitter splits the eth_getLogs calls of a chain
+type getLogsSplitter struct {
+	maxRange    uint64
+	maxChunks   uint64
+	concurrency int
+	chainId     uint64
+}
+
+// getLogsChunk returns the block range of the i-th chunk of from-to
+func getLogsChunk(from, to, maxRange, i uint64) (start, end uint64) {
+	start = from + i*maxRange
+	end = start + maxRange - 1
+	if end > to || end < start {
+		end = to
+	}
+	return start, end
+}
+
+// headBlockNumber returns the latest block number of the upstream
+func headBlockNumber(req *http.Request, next http.Handler) (uint64, error) {
+	result, err := callMethod(req, next, MethodEthBlockNumber)
+	if err != nil {
+		return 0, err
+	}
+	var number hexutil.Uint64
+	if err = json.Unmarshal(result, &number); err != nil {
+		return 0, err
+	}
+	return uint64(number), nil
+}
+
+// lazyHeadBlockNumber returns a func fetching the head block number on the first call, which is shared by the calls
+// of a request
+func lazyHeadBlockNumber(req *http.Request, next http.Handler) func() (uint64, error) {
+	var number uint64
+	var err error
+	fetched := false
+	return func() (uint64, error) {
+		if !fetched {
+			number, err = headBlockNumber(req, next)
+			fetched = true
+		}
+		return number, err
+	}
+}
+
+// getLogsSplitHandler answers the eth_getLogs calls over maxRange with the merged logs of the chunks,
+// the block tags other than "earliest" and the missing bounds are resolved as the head block
+func getLogsSplitHandler(s *getLogsSplitter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := readReqBody(req)
+		if err != nil {
+			writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, "invalid request", nil)
+			return
+		}
+		msgs, batch, err := parseJsonRpcBody(body)
+		if err != nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		head := lazyHeadBlockNumber(req, next)
+		var forwarded, answered []*jsonrpcMessage
+		for _, msg := range msgs {
+			if msg.Method == MethodEthGetLogs {
+				from, to, ok, err := resolveGetLogsRange(msg.Params, head)
+				if err != nil {
+					log.Warnf("fail to get head for eth_getLogs, chain:%d, client:%s, err:%s", s.chainId, clientIdentity(req), redact(err.Error()))
+					answered = append(answered, newJsonRpcError(msg.ID, errCodeInternal, "fail to get head block from upstream", nil))
+					continue
+				}
+				if ok && to >= from && to-from >= s.maxRange {
+					incCounter(1, "getlogs", "split")
+					answered = append(answered, s.split(req, next, msg, from, to, head))
+					continue
+				}
+			}
+			forwarded = append(forwarded, msg)
+		}
+		if len(answered) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		serveWithAnswered(w, req, next, batch, forwarded, answered)
+	})
+}
+
+// split sends the chunks of the call with at most concurrency in flight, the first error fails the call.
+// The chunks are built when they are sent, so that a failed call doesn't build the rest. headNumber is shared by
+// the calls of the request
+func (s *getLogsSplitter) split(req *http.Request, next http.Handler, msg *jsonrpcMessage, from, to uint64,
+	headNumber func() (uint64, error)) *jsonrpcMessage {
+	var args []map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Params, &args); err != nil || len(args) == 0 {
+		return newJsonRpcError(msg.ID, errCodeInvalidParams, "invalid params", nil)
+	}
+	head, err := headNumber()
+	if err != nil {
+		log.Warnf("fail to get head for eth_getLogs, chain:%d, client:%s, err:%s", s.chainId, clientIdentity(req), redact(err.Error()))
+		return newJsonRpcError(msg.ID, errCodeInternal, "fail to get head block from upstream", nil)
+	}
+	if from > head {
+		return &jsonrpcMessage{Version: "2.0", ID: msg.ID, Result: json.RawMessage("[]")}
+	}
+	if to > head {
+		to = head
+	}
+	if (to-from)/s.maxRange >= s.maxChunks {
+		log.Warnf("reject eth_getLogs of blocks %d-%d, chain:%d, client:%s", from, to, s.chainId, clientIdentity(req))
+		incCounter(1, "getlogs", "rejected")
+		return newJsonRpcError(msg.ID, errCodeInvalidParams,
+			fmt.Sprintf("block range too large, blocks %d-%d, limit is %d blocks", from, to, s.maxRange*s.maxChunks), nil)
+	}
+	chunks := (to-from)/s.maxRange + 1
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	chunkReq := req.WithContext(ctx)
+
+	results := make([][]json.RawMessage, chunks)
+	var lock sync.Mutex
+	var failed *jsonrpcMessage
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, s.concurrency)
+	for i := uint64(0); i < chunks; i++ {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		start, end := getLogsChunk(from, to, s.maxRange, i)
+		filter := make(map[string]json.RawMessage)
+		for k, v := range args[0] {
+			filter[k] = v
+		}
+		filter["fromBlock"], _ = json.Marshal(hexutil.Uint64(start))
+		filter["toBlock"], _ = json.Marshal(hexutil.Uint64(end))
+		params, _ := json.Marshal(append([]map[string]json.RawMessage{filter}, args[1:]...))
+		chunk := &jsonrpcMessage{Version: "2.0", ID: json.RawMessage("1"), Method: MethodEthGetLogs, Params: params}
+		wg.Add(1)
+		go func(i uint64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			resps, buf := forwardCalls(chunkReq, next, []*jsonrpcMessage{chunk}, false)
+			var fail *jsonrpcMessage
+			switch {
+			case resps == nil:
+				fail = newJsonRpcError(msg.ID, errCodeInternal, "fail to get logs from upstream", map[string]int{"status": buf.status})
+			case resps[0].Error != nil:
+				fail = newJsonRpcError(msg.ID, resps[0].Error.Code, resps[0].Error.Message, resps[0].Error.Data)
+			case json.Unmarshal(resps[0].Result, &results[i]) != nil:
+				fail = newJsonRpcError(msg.ID, errCodeInternal, "invalid logs from upstream", nil)
+			}
+			if fail == nil {
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if failed == nil {
+				failed = fail
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if failed != nil {
+		log.Warnf("fail to get logs of blocks %d-%d in %d chunks, chain:%d, client:%s, err:%s",
+			from, to, chunks, s.chainId, clientIdentity(req), redact(failed.Error.Message))
+		return failed
+	}
+	if err := req.Context().Err(); err != nil {
+		return newJsonRpcError(msg.ID, errCodeInternal, err.Error(), nil)
+	}
+	logs := make([]json.RawMessage, 0)
+	for _, result := range results {
+		logs = append(logs, result...)
+	}
+	result, _ := json.Marshal(logs)
+	return &jsonrpcMessage{Version: "2.0", ID: msg.ID, Result: result}
+}
@@ -0,0 +1,139 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestGetLogsChunk(t *testing.T) {
+	tests := []struct {
+		name               string
+		from, to, maxRange uint64
+		i                  uint64
+		wantStart, wantEnd uint64
+	}{
+		{"first", 0, 2999, 1000, 0, 0, 999},
+		{"middle", 0, 2999, 1000, 1, 1000, 1999},
+		{"last full", 0, 2999, 1000, 2, 2000, 2999},
+		{"last partial", 10, 2500, 1000, 2, 2010, 2500},
+		{"single block", 5, 5, 1000, 0, 5, 5},
+		{"range of one", 5, 7, 1, 2, 7, 7},
+		{"end overflow", math.MaxUint64 - 10, math.MaxUint64, 1000, 0, math.MaxUint64 - 10, math.MaxUint64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := getLogsChunk(tt.from, tt.to, tt.maxRange, tt.i)
+			if start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("getLogsChunk(%d, %d, %d, %d) = %d-%d, want %d-%d",
+					tt.from, tt.to, tt.maxRange, tt.i, start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+// logsUpstream answers eth_blockNumber with head and eth_getLogs with one log per block of the range
+type logsUpstream struct {
+	head   uint64
+	lock   sync.Mutex
+	ranges [][2]uint64
+}
+
+func (u *logsUpstream) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var msg jsonrpcMessage
+	if err := json.NewDecoder(req.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := &jsonrpcMessage{Version: "2.0", ID: msg.ID}
+	switch msg.Method {
+	case MethodEthBlockNumber:
+		resp.Result, _ = json.Marshal(hexutil.Uint64(u.head))
+	case MethodEthGetLogs:
+		var args []struct {
+			FromBlock hexutil.Uint64 `json:"fromBlock"`
+			ToBlock   hexutil.Uint64 `json:"toBlock"`
+		}
+		if err := json.Unmarshal(msg.Params, &args); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		u.lock.Lock()
+		u.ranges = append(u.ranges, [2]uint64{uint64(args[0].FromBlock), uint64(args[0].ToBlock)})
+		u.lock.Unlock()
+		var logs []map[string]hexutil.Uint64
+		for n := args[0].FromBlock; n <= args[0].ToBlock; n++ {
+			logs = append(logs, map[string]hexutil.Uint64{"blockNumber": n})
+		}
+		resp.Result, _ = json.Marshal(logs)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestGetLogsSplit(t *testing.T) {
+	tests := []struct {
+		name       string
+		head       uint64
+		params     string
+		wantChunks int
+		wantLogs   int
+		wantCode   int
+	}{
+		{"split", 100, `[{"fromBlock":"0x0","toBlock":"0x18"}]`, 3, 25, 0},
+		{"clamped to head", 20, `[{"fromBlock":"0x0","toBlock":"0xffffffffffffffff"}]`, 3, 21, 0},
+		{"from over head", 20, `[{"fromBlock":"0x20","toBlock":"0x40"}]`, 0, 0, 0},
+		{"too many chunks", 1000, `[{"fromBlock":"0x0","toBlock":"0x2000000"}]`, 0, 0, errCodeInvalidParams},
+		{"max chunks", 1000, `[{"fromBlock":"0x0","toBlock":"0x27"}]`, 4, 40, 0},
+		{"not split", 100, `[{"fromBlock":"0x0","toBlock":"0x9"}]`, 1, 10, 0},
+		{"to latest", 25, `[{"fromBlock":"0x0","toBlock":"latest"}]`, 3, 26, 0},
+		{"missing to", 25, `[{"fromBlock":"0x5"}]`, 3, 21, 0},
+		{"from latest", 25, `[{"fromBlock":"latest","toBlock":"0x40"}]`, 1, 1, 0},
+		{"earliest to latest", 1000, `[{"fromBlock":"earliest","toBlock":"latest"}]`, 0, 0, errCodeInvalidParams},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &logsUpstream{head: tt.head}
+			handler := getLogsSplitHandler(&getLogsSplitter{maxRange: 10, maxChunks: 4, concurrency: 2}, upstream)
+			body := `{"jsonrpc":"2.0","id":7,"method":"eth_getLogs","params":` + tt.params + `}`
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+			var resp jsonrpcMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid response %s: %v", rec.Body.String(), err)
+			}
+			if string(resp.ID) != "7" {
+				t.Errorf("id = %s, want 7", resp.ID)
+			}
+			if tt.wantCode != 0 {
+				if resp.Error == nil || resp.Error.Code != tt.wantCode {
+					t.Fatalf("error = %+v, want code %d", resp.Error, tt.wantCode)
+				}
+				return
+			}
+			if resp.Error != nil {
+				t.Fatalf("unexpected error %+v", resp.Error)
+			}
+			var logs []map[string]hexutil.Uint64
+			if err := json.Unmarshal(resp.Result, &logs); err != nil {
+				t.Fatal(err)
+			}
+			if len(logs) != tt.wantLogs {
+				t.Errorf("got %d logs, want %d", len(logs), tt.wantLogs)
+			}
+			for i := 1; i < len(logs); i++ {
+				if logs[i]["blockNumber"] != logs[i-1]["blockNumber"]+1 {
+					t.Fatalf("logs out of order at %d", i)
+				}
+			}
+			if len(upstream.ranges) != tt.wantChunks {
+				t.Errorf("got %d upstream eth_getLogs calls, want %d", len(upstream.ranges), tt.wantChunks)
+			}
+		})
+	}
+}
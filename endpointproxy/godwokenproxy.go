@@ -17,11 +17,11 @@ type GodwokenProxy struct {
 }
 
 // NewProxy takes target host and creates a reverse proxy
-func (h *GodwokenProxy) startGodwokenProxy(targetHost string, port int, chainId uint64) error {
+func (h *GodwokenProxy) newGodwokenProxy(targetHost string) (*httputil.ReverseProxy, error) {
 	var err error
 	h.godwokenTargetUrl, err = url.Parse(targetHost)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	p := httputil.NewSingleHostReverseProxy(h.godwokenTargetUrl)
 	originalDirector := p.Director
@@ -29,10 +29,7 @@ func (h *GodwokenProxy) startGodwokenProxy(targetHost string, port int, chainId
 		originalDirector(req)
 		h.modifyGodwokenRequest(req)
 	}
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", proxyRequestHandler(p))
-	go startCustomProxyByPort(port, mux, chainId, targetHost)
-	return nil
+	return p, nil
 }
 
 func (h *GodwokenProxy) modifyGodwokenRequest(req *http.Request) {
@@ -47,6 +44,8 @@ func (h *GodwokenProxy) modifyGodwokenRequest(req *http.Request) {
 	msg := &jsonrpcMessage{}
 	if err = json.Unmarshal(reqStr, msg); err != nil {
 		log.Errorf("fail to unmarshal this godwoken req body err:%s", err.Error())
+		// e.g. batch request, forward it as it is
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqStr))
 		return
 	}
 	if msg.Method == MethodEthCall {
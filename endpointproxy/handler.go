@@ -0,0 +1,294 @@
+package endpointproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+
+	"github.com/celer-network/goutils/log"
+)
+
+const (
+	errCodeInvalidRequest = -32600
+	errCodeInvalidParams  = -32602
+	errCodeInternal       = -32603
+	errCodeUnauthorized   = -32001
+)
+
+// newProxyHandler wraps the reverse proxy with the handlers enabled in cfg
+func newProxyHandler(p *httputil.ReverseProxy, cfg *ProxyConfig, chainId uint64) (http.Handler, error) {
+	p.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		if errors.Is(err, errUpstreamLimitExceeded) {
+			log.Warnf("upstream rate limit exceeded, chain:%d, client:%s", chainId, clientIdentity(req))
+			incCounter(1, "ratelimit", "upstream", "rejected")
+			writeLimitExceeded(w, nil, false, upstreamLimitMaxWait)
+			return
+		}
+		log.Errorf("proxy error, chain:%d, client:%s, err:%s", chainId, clientIdentity(req), redact(err.Error()))
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	var handler http.Handler = http.HandlerFunc(proxyRequestHandler(p))
+	if chainId == harmonyChainId || chainId == harmonyTestnetChainId {
+		handler = harmonyHandler(cfg.Harmony, chainId, handler)
+	}
+	if chainId == celoChainId || chainId == celoTestnetChainId {
+		currency, err := cfg.Celo.feeCurrency()
+		if err != nil {
+			return nil, err
+		}
+		handler = celoFeeHandler(currency, chainId, handler)
+	}
+	fixups, err := cfg.Fixup.rules(chainId)
+	if err != nil {
+		return nil, err
+	}
+	if len(fixups) > 0 {
+		handler = fixupHandler(fixups, chainId, handler)
+	}
+	policy := cfg.Normalize.policy(chainId)
+	if policy.enabled() {
+		if err = policy.Headers.validate(); err != nil {
+			return nil, err
+		}
+		handler = normalizeHandler(policy, chainId, handler)
+	}
+	// outside the fixups and normalization, so that the blocks are checked as the clients get them
+	if cfg.BlockHashCheck.enabled(chainId) {
+		handler = blockHashCheckHandler(cfg.BlockHashCheck, chainId, handler)
+	}
+	if cfg.Cache != nil {
+		if handler, err = cacheHandler(cfg.Cache, chainId, handler); err != nil {
+			return nil, err
+		}
+	}
+	// outside the cache, so that the chunks are cached
+	if maxRange := cfg.GetLogs.maxRange(chainId); maxRange > 0 {
+		handler = getLogsSplitHandler(&getLogsSplitter{
+			maxRange:    maxRange,
+			maxChunks:   cfg.GetLogs.maxChunks(),
+			concurrency: cfg.GetLogs.concurrency(),
+			chainId:     chainId,
+		}, handler)
+	}
+	if cfg.RateLimit != nil && (cfg.RateLimit.PerClient != nil || len(cfg.RateLimit.PerMethod) > 0) {
+		handler = rateLimitHandler(cfg.RateLimit, chainId, handler)
+	}
+	if cfg.Firewall != nil {
+		if rules := cfg.Firewall.rules(chainId); rules.enabled() {
+			handler = firewallHandler(rules, chainId, handler)
+		}
+	}
+	if cfg.Auth != nil {
+		if auths := cfg.Auth.authenticators(); len(auths) > 0 {
+			handler = authHandler(auths, handler)
+		}
+	}
+	// the body is bounded before any other handler reads it
+	handler = limitHandler(cfg.Limits, chainId, handler)
+	if cfg.Cors != nil && len(cfg.Cors.AllowedOrigins) > 0 {
+		// preflight requests carry no credential, they are answered before auth
+		handler = corsHandler(cfg.Cors, p, handler)
+	}
+	return handler, nil
+}
+
+// baseTransport returns the transport used by the reverse proxy to reach the upstream
+func baseTransport(p *httputil.ReverseProxy) http.RoundTripper {
+	if p.Transport != nil {
+		return p.Transport
+	}
+	return http.DefaultTransport
+}
+
+// writeJsonRpcError answers the request with a json rpc error instead of forwarding it
+func writeJsonRpcError(w http.ResponseWriter, status int, id json.RawMessage, code int, message string, data interface{}) {
+	writeJsonRpcResponse(w, status, newJsonRpcError(id, code, message, data))
+}
+
+// writeJsonRpcErrors answers every call of a single or batch request with the same json rpc error
+func writeJsonRpcErrors(w http.ResponseWriter, status int, msgs []*jsonrpcMessage, batch bool, code int, message string, data interface{}) {
+	if !batch {
+		var id json.RawMessage
+		if len(msgs) > 0 {
+			id = msgs[0].ID
+		}
+		writeJsonRpcError(w, status, id, code, message, data)
+		return
+	}
+	resps := make([]*jsonrpcMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		resps = append(resps, newJsonRpcError(msg.ID, code, message, data))
+	}
+	writeJsonRpcResponse(w, status, resps)
+}
+
+func newJsonRpcError(id json.RawMessage, code int, message string, data interface{}) *jsonrpcMessage {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &jsonrpcMessage{
+		Version: "2.0",
+		ID:      id,
+		Error:   &jsonError{Code: code, Message: message, Data: data},
+	}
+}
+
+func writeJsonRpcResponse(w http.ResponseWriter, status int, v interface{}) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("fail to marshal json rpc response, err:%s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+// serveWithAnswered writes the responses answered by the proxy, e.g. errors of the rejected calls, and forwards
+// the other calls, the responses of a batch are merged into one
+func serveWithAnswered(w http.ResponseWriter, req *http.Request, next http.Handler, batch bool, forwarded, answered []*jsonrpcMessage) {
+	if !batch {
+		writeJsonRpcResponse(w, http.StatusOK, answered[0])
+		return
+	}
+	var answeredResps []*jsonrpcMessage
+	for _, resp := range answered {
+		// notifications have no response
+		if len(resp.ID) > 0 && string(resp.ID) != "null" {
+			answeredResps = append(answeredResps, resp)
+		}
+	}
+	if len(forwarded) == 0 {
+		writeJsonRpcResponse(w, http.StatusOK, answeredResps)
+		return
+	}
+	resps, buf := forwardCalls(req, next, forwarded, true)
+	if resps == nil {
+		buf.writeTo(w, buf.body.Bytes())
+		return
+	}
+	merged, _ := json.Marshal(append(resps, answeredResps...))
+	buf.writeTo(w, merged)
+}
+
+// forwardCalls sends the calls to next as a single or batch request, resps is nil if the response is not
+// a json rpc response of the calls, e.g. http error, then buf should be written as it is
+func forwardCalls(req *http.Request, next http.Handler, calls []*jsonrpcMessage, batch bool) (resps []*jsonrpcMessage, buf *responseBuffer) {
+	var body []byte
+	var err error
+	if batch {
+		body, err = json.Marshal(calls)
+	} else {
+		body, err = json.Marshal(calls[0])
+	}
+	buf = newResponseBuffer()
+	if err != nil {
+		writeJsonRpcError(buf, http.StatusInternalServerError, nil, errCodeInvalidRequest, "invalid request", nil)
+		return nil, buf
+	}
+	outReq := req.Clone(req.Context())
+	outReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	outReq.ContentLength = int64(len(body))
+	// the responses are parsed, ask for the plain body
+	outReq.Header.Del("Accept-Encoding")
+	next.ServeHTTP(buf, outReq)
+	if buf.status != http.StatusOK {
+		return nil, buf
+	}
+	resps, respBatch, err := parseJsonRpcBody(buf.body.Bytes())
+	if err != nil || respBatch != batch {
+		return nil, buf
+	}
+	return resps, buf
+}
+
+// serveRewritten forwards msgs to next and writes the responses changed by rewrite, the upstream response is
+// written as it is if none is changed
+func serveRewritten(w http.ResponseWriter, req *http.Request, next http.Handler, msgs []*jsonrpcMessage, batch bool, rewrite func(resp *jsonrpcMessage) bool) {
+	resps, buf := forwardCalls(req, next, msgs, batch)
+	if resps == nil {
+		buf.writeTo(w, buf.body.Bytes())
+		return
+	}
+	changed := false
+	for _, resp := range resps {
+		if rewrite(resp) {
+			changed = true
+		}
+	}
+	if !changed {
+		buf.writeTo(w, buf.body.Bytes())
+		return
+	}
+	var data []byte
+	var err error
+	if batch {
+		data, err = json.Marshal(resps)
+	} else {
+		data, err = json.Marshal(resps[0])
+	}
+	if err != nil {
+		buf.writeTo(w, buf.body.Bytes())
+		return
+	}
+	buf.writeTo(w, data)
+}
+
+// callMethod sends a call of the proxy itself to next, e.g. to get the data needed by a fixup, and returns the result
+func callMethod(req *http.Request, next http.Handler, method string, params ...interface{}) (json.RawMessage, error) {
+	if params == nil {
+		params = []interface{}{}
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	call := &jsonrpcMessage{Version: "2.0", ID: json.RawMessage("1"), Method: method, Params: data}
+	resps, buf := forwardCalls(req, next, []*jsonrpcMessage{call}, false)
+	if resps == nil {
+		return nil, fmt.Errorf("%s failed with http status %d", method, buf.status)
+	}
+	if resps[0].Error != nil {
+		return nil, fmt.Errorf("%s failed, err:%s", method, resps[0].Error.Message)
+	}
+	return resps[0].Result, nil
+}
+
+// responseBuffer keeps the response of the next handler in memory, so that it can be changed before writing
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header), status: http.StatusOK}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// writeTo writes the buffered status and header with body to w
+func (b *responseBuffer) writeTo(w http.ResponseWriter, body []byte) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(b.status)
+	w.Write(body)
+}
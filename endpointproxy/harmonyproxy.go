@@ -3,13 +3,17 @@ package endpointproxy
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/celer-network/goutils/log"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
 type HarmonyProxy struct {
@@ -17,11 +21,11 @@ type HarmonyProxy struct {
 }
 
 // NewProxy takes target host and creates a reverse proxy
-func (h *HarmonyProxy) startHarmonyProxy(targetHost string, port int, chainId uint64) error {
+func (h *HarmonyProxy) newHarmonyProxy(targetHost string) (*httputil.ReverseProxy, error) {
 	var err error
 	h.harmonyTargetUrl, err = url.Parse(targetHost)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	p := httputil.NewSingleHostReverseProxy(h.harmonyTargetUrl)
 	originalDirector := p.Director
@@ -29,10 +33,7 @@ func (h *HarmonyProxy) startHarmonyProxy(targetHost string, port int, chainId ui
 		originalDirector(req)
 		h.modifyHarmonyRequest(req)
 	}
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", proxyRequestHandler(p))
-	go startCustomProxyByPort(port, mux, chainId, targetHost)
-	return nil
+	return p, nil
 }
 
 func (h *HarmonyProxy) modifyHarmonyRequest(req *http.Request) {
@@ -47,6 +48,8 @@ func (h *HarmonyProxy) modifyHarmonyRequest(req *http.Request) {
 	msg := &jsonrpcMessage{}
 	if err = json.Unmarshal(reqStr, msg); err != nil {
 		log.Errorf("fail to unmarshal this harmony req body err:%s", err.Error())
+		// e.g. batch request, forward it as it is
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqStr))
 		return
 	}
 	if msg.Method == MethodEthGetCode {
@@ -61,3 +64,261 @@ func (h *HarmonyProxy) modifyHarmonyRequest(req *http.Request) {
 	req.Body = ioutil.NopCloser(bytes.NewReader(newMsg))
 	req.ContentLength = int64(len(newMsg))
 }
+
+const (
+	// HarmonyShardHeader selects the shard of a request, the requests without it go to the endpoint of the proxy
+	HarmonyShardHeader = "X-Harmony-Shard"
+
+	harmonyAddressPrefix = "one1"
+	bech32Charset        = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+// HarmonyConfig sets the shard endpoints of the harmony proxies
+type HarmonyConfig struct {
+	// Shards maps the shard ids to their endpoints, the requests with HarmonyShardHeader are sent to the endpoint
+	// of the shard, the upstream settings apply to them as well
+	Shards map[uint32]string `json:"shards"`
+}
+
+// director returns the director which sends the requests to the endpoint of the shard in HarmonyShardHeader
+func (c *HarmonyConfig) director(original func(*http.Request), upstream *UpstreamConfig) (func(*http.Request), error) {
+	shards := make(map[string]*url.URL)
+	for shard, endpoint := range c.Shards {
+		if upstream != nil {
+			var err error
+			if endpoint, err = upstream.upstreamEndpoint(endpoint); err != nil {
+				return nil, err
+			}
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint of harmony shard %d, err:%w", shard, redactEndpointError(endpoint, err))
+		}
+		shards[strconv.FormatUint(uint64(shard), 10)] = u
+	}
+	return func(req *http.Request) {
+		path, query := req.URL.Path, req.URL.RawQuery
+		original(req)
+		target, ok := shards[req.Header.Get(HarmonyShardHeader)]
+		req.Header.Del(HarmonyShardHeader)
+		if !ok {
+			return
+		}
+		req.URL.Scheme = target.Scheme
+		req.URL.Host = target.Host
+		req.Host = target.Host
+		req.URL.Path = strings.TrimRight(target.Path, "/") + "/" + strings.TrimLeft(path, "/")
+		req.URL.RawPath = ""
+		if target.RawQuery == "" || query == "" {
+			req.URL.RawQuery = target.RawQuery + query
+		} else {
+			req.URL.RawQuery = target.RawQuery + "&" + query
+		}
+	}, nil
+}
+
+// harmonyHandler rejects the requests of unknown shards, and makes the results of the eth namespace decodable by
+// eth client, i.e. the one addresses are converted to hex, and the staking txs are removed from the blocks
+func harmonyHandler(cfg *HarmonyConfig, chainId uint64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if shard := req.Header.Get(HarmonyShardHeader); shard != "" {
+			id, err := strconv.ParseUint(shard, 10, 32)
+			if _, ok := cfg.shards()[uint32(id)]; err != nil || !ok {
+				writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, fmt.Sprintf("unknown shard %s", shard), nil)
+				return
+			}
+		}
+		body, err := readReqBody(req)
+		if err != nil {
+			writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, "invalid request", nil)
+			return
+		}
+		msgs, batch, err := parseJsonRpcBody(body)
+		if err != nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		calls := make(map[string]*jsonrpcMessage)
+		for _, msg := range msgs {
+			if len(msg.ID) > 0 && string(msg.ID) != "null" && strings.HasPrefix(msg.Method, "eth_") {
+				calls[string(msg.ID)] = msg
+			}
+		}
+		if len(calls) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		serveRewritten(w, req, next, msgs, batch, func(resp *jsonrpcMessage) bool {
+			call, ok := calls[string(resp.ID)]
+			if !ok || resp.Error != nil || len(resp.Result) == 0 || string(resp.Result) == "null" {
+				return false
+			}
+			result, changed := hexHarmonyAddresses(resp.Result)
+			if call.Method == MethodEthGetBlockByNumber || call.Method == MethodEthGetBlockByHash {
+				if block, ok := removeStakingTxs(result); ok {
+					result, changed = block, true
+				}
+			}
+			if !changed {
+				return false
+			}
+			resp.Result = result
+			return true
+		})
+	})
+}
+
+func (c *HarmonyConfig) shards() map[uint32]string {
+	if c == nil {
+		return nil
+	}
+	return c.Shards
+}
+
+// removeStakingTxs removes the staking txs of the block, whose type is a staking directive instead of a hex number
+func removeStakingTxs(result json.RawMessage) (json.RawMessage, bool) {
+	var block jsonObject
+	if json.Unmarshal(result, &block) != nil || block == nil {
+		return result, false
+	}
+	_, changed := block["stakingTransactions"]
+	delete(block, "stakingTransactions")
+	var txs []json.RawMessage
+	if json.Unmarshal(block["transactions"], &txs) == nil {
+		kept := txs[:0]
+		for _, raw := range txs {
+			var tx jsonObject
+			if json.Unmarshal(raw, &tx) == nil && tx != nil && !tx.missing("type") {
+				if _, err := hexutil.DecodeUint64(tx.getString("type")); err != nil {
+					changed = true
+					continue
+				}
+			}
+			kept = append(kept, raw)
+		}
+		block.set("transactions", kept)
+	}
+	if !changed {
+		return result, false
+	}
+	data, err := json.Marshal(block)
+	return data, err == nil
+}
+
+// hexHarmonyAddresses converts the one addresses in the json value to hex addresses
+func hexHarmonyAddresses(v json.RawMessage) (json.RawMessage, bool) {
+	if len(v) == 0 {
+		return v, false
+	}
+	changed := false
+	switch v[0] {
+	case '"':
+		var s string
+		// the upper case bech32 addresses are valid too
+		if json.Unmarshal(v, &s) != nil || !strings.HasPrefix(strings.ToLower(s), harmonyAddressPrefix) {
+			return v, false
+		}
+		addr, ok := decodeOneAddress(s)
+		if !ok {
+			return v, false
+		}
+		data, _ := json.Marshal(addr)
+		return data, true
+	case '{':
+		var obj jsonObject
+		if json.Unmarshal(v, &obj) != nil {
+			return v, false
+		}
+		for k, field := range obj {
+			if fixed, ok := hexHarmonyAddresses(field); ok {
+				obj[k] = fixed
+				changed = true
+			}
+		}
+		if changed {
+			data, err := json.Marshal(obj)
+			return data, err == nil
+		}
+	case '[':
+		var items []json.RawMessage
+		if json.Unmarshal(v, &items) != nil {
+			return v, false
+		}
+		for i, item := range items {
+			if fixed, ok := hexHarmonyAddresses(item); ok {
+				items[i] = fixed
+				changed = true
+			}
+		}
+		if changed {
+			data, err := json.Marshal(items)
+			return data, err == nil
+		}
+	}
+	return v, false
+}
+
+// decodeOneAddress decodes a bech32 one address, i.e. one1 followed by the 20 bytes and the checksum
+func decodeOneAddress(s string) (common.Address, bool) {
+	// bech32 is either all lower or all upper case
+	if s != strings.ToLower(s) && s != strings.ToUpper(s) {
+		return common.Address{}, false
+	}
+	s = strings.ToLower(s)
+	if !strings.HasPrefix(s, harmonyAddressPrefix) {
+		return common.Address{}, false
+	}
+	hrp, data := "one", s[len(harmonyAddressPrefix):]
+	// 20 bytes in 5 bit groups and the 6 groups of checksum
+	if len(data) != 32+6 {
+		return common.Address{}, false
+	}
+	values := make([]byte, len(data))
+	for i := range data {
+		index := strings.IndexByte(bech32Charset, data[i])
+		if index < 0 {
+			return common.Address{}, false
+		}
+		values[i] = byte(index)
+	}
+	var expanded []byte
+	for i := range hrp {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := range hrp {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	if bech32Polymod(append(expanded, values...)) != 1 {
+		return common.Address{}, false
+	}
+	var addr []byte
+	acc, bits := 0, 0
+	for _, value := range values[:len(values)-6] {
+		acc = acc<<5 | int(value)
+		bits += 5
+		for bits >= 8 {
+			bits -= 8
+			addr = append(addr, byte(acc>>bits))
+		}
+	}
+	if len(addr) != common.AddressLength {
+		return common.Address{}, false
+	}
+	return common.BytesToAddress(addr), true
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
@@ -0,0 +1,65 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestDecodeOneAddress(t *testing.T) {
+	const valid = "one1pdv9lrdwl0rg5vglh4xtyrv3wjk3wsqket7zxy"
+	tests := []struct {
+		name   string
+		s      string
+		want   common.Address
+		wantOk bool
+	}{
+		{"valid", valid, common.HexToAddress("0x0B585F8DaEfBC68a311FbD4cB20d9174aD174016"), true},
+		{"upper case", strings.ToUpper(valid), common.HexToAddress("0x0B585F8DaEfBC68a311FbD4cB20d9174aD174016"), true},
+		{"mixed case", "one1Pdv9lrdwl0rg5vglh4xtyrv3wjk3wsqket7zxy", common.Address{}, false},
+		{"invalid checksum", "one1pdv9lrdwl0rg5vglh4xtyrv3wjk3wsqket7zxz", common.Address{}, false},
+		{"swapped chars", "one1dpv9lrdwl0rg5vglh4xtyrv3wjk3wsqket7zxy", common.Address{}, false},
+		{"too short", "one1pdv9lrdwl0rg5vglh4xtyrv3wjk3wsqket7zx", common.Address{}, false},
+		{"too long", valid + "q", common.Address{}, false},
+		{"invalid char", "one1bdv9lrdwl0rg5vglh4xtyrv3wjk3wsqket7zxy", common.Address{}, false},
+		{"other prefix", "bc1pdv9lrdwl0rg5vglh4xtyrv3wjk3wsqket7zxy", common.Address{}, false},
+		{"prefix only", "one1", common.Address{}, false},
+		{"empty", "", common.Address{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decodeOneAddress(tt.s)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("decodeOneAddress(%q) = %s, %v, want %s, %v", tt.s, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestHexHarmonyAddresses(t *testing.T) {
+	const valid = "one1pdv9lrdwl0rg5vglh4xtyrv3wjk3wsqket7zxy"
+	const hex = `"0x0b585f8daefbc68a311fbd4cb20d9174ad174016"`
+	tests := []struct {
+		name        string
+		v           string
+		want        string
+		wantChanged bool
+	}{
+		{"address", `"` + valid + `"`, hex, true},
+		{"upper case address", `"` + strings.ToUpper(valid) + `"`, hex, true},
+		{"nested object", `{"tx":{"from":"` + strings.ToUpper(valid) + `","to":"` + valid + `","value":"0x1"},"logs":[{"address":"` + valid + `"}]}`,
+			`{"logs":[{"address":` + hex + `}],"tx":{"from":` + hex + `,"to":` + hex + `,"value":"0x1"}}`, true},
+		{"hex address", hex, hex, false},
+		{"invalid address", `{"from":"one1pdv9lrdwl0rg5vglh4xtyrv3wjk3wsqket7zxz"}`, `{"from":"one1pdv9lrdwl0rg5vglh4xtyrv3wjk3wsqket7zxz"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := hexHarmonyAddresses(json.RawMessage(tt.v))
+			if changed != tt.wantChanged || string(got) != tt.want {
+				t.Errorf("hexHarmonyAddresses(%s) = %s, %v, want %s, %v", tt.v, got, changed, tt.want, tt.wantChanged)
+			}
+		})
+	}
+}
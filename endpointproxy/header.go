@@ -0,0 +1,91 @@
+package endpointproxy
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	ForkShanghai = "shanghai"
+	ForkCancun   = "cancun"
+)
+
+// forkOrder orders the forks which add header fields
+var forkOrder = map[string]int{
+	ForkShanghai: 1,
+	ForkCancun:   2,
+}
+
+// postMergeHeaderFields are the optional header fields after baseFeePerGas in the order of the header,
+// a field in the rlp of the header needs all the fields before it
+var postMergeHeaderFields = []struct {
+	name string
+	fork string
+	zero interface{}
+}{
+	{"withdrawalsRoot", ForkShanghai, types.EmptyRootHash},
+	{"blobGasUsed", ForkCancun, hexutil.Uint64(0)},
+	{"excessBlobGas", ForkCancun, hexutil.Uint64(0)},
+	{"parentBeaconBlockRoot", ForkCancun, common.Hash{}},
+}
+
+// HeaderPolicy makes the post-merge fields of the block headers consistent, i.e. withdrawalsRoot, blobGasUsed,
+// excessBlobGas and parentBeaconBlockRoot
+type HeaderPolicy struct {
+	// Strip removes the post-merge fields and the withdrawals, for the chains which return them with meaningless values
+	Strip bool `json:"strip"`
+	// Synthesize sets the missing post-merge fields before the last one returned, with the empty withdrawals,
+	// zero blob gas and zero beacon root
+	Synthesize bool `json:"synthesize"`
+	// Fork makes Synthesize set the fields up to the fork even if none is returned, shanghai or cancun
+	Fork string `json:"fork"`
+}
+
+func (p *HeaderPolicy) enabled() bool {
+	return p != nil && (p.Strip || p.Synthesize)
+}
+
+func (p *HeaderPolicy) validate() error {
+	if p == nil {
+		return nil
+	}
+	if _, ok := forkOrder[p.Fork]; p.Fork != "" && !ok {
+		return fmt.Errorf("invalid header policy, unknown fork %s", p.Fork)
+	}
+	return nil
+}
+
+// normalize changes the block in place, and tells if it is changed
+func (p *HeaderPolicy) normalize(block jsonObject) bool {
+	before := block.clone()
+	if p.Strip {
+		for _, field := range postMergeHeaderFields {
+			delete(block, field.name)
+		}
+		delete(block, "withdrawals")
+		return block.changed(before)
+	}
+	if !p.Synthesize {
+		return false
+	}
+	last := -1
+	for i, field := range postMergeHeaderFields {
+		if !block.missing(field.name) || forkOrder[field.fork] <= forkOrder[p.Fork] {
+			last = i
+		}
+	}
+	for _, field := range postMergeHeaderFields[:last+1] {
+		if !block.missing(field.name) {
+			continue
+		}
+		block.set(field.name, field.zero)
+		// the empty withdrawals match the synthesized root
+		if field.name == "withdrawalsRoot" && block.missing("withdrawals") {
+			block.set("withdrawals", []struct{}{})
+		}
+	}
+	return block.changed(before)
+}
@@ -0,0 +1,82 @@
+package endpointproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ipcEndpointPrefix = "ipc:"
+	// the reverse proxy needs a http target, requests to it are sent to the ipc path by ipcTransport
+	ipcPlaceholderEndpoint = "http://ipc"
+	ipcDialTimeout         = 5 * time.Second
+)
+
+// ipcPath returns the ipc path if endpoint is ipc:{path} or an absolute file path like geth.ipc
+func ipcPath(endpoint string) (string, bool) {
+	if strings.HasPrefix(endpoint, ipcEndpointPrefix) {
+		return strings.TrimPrefix(endpoint, ipcEndpointPrefix), true
+	}
+	if filepath.IsAbs(endpoint) {
+		return endpoint, true
+	}
+	return "", false
+}
+
+// ipcTransport sends the json rpc request in body to a geth style ipc endpoint, messages are newline delimited json
+type ipcTransport struct {
+	path string
+}
+
+func (t *ipcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqData []byte
+	if req.Body != nil {
+		var err error
+		reqData, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	dialer := net.Dialer{Timeout: ipcDialTimeout}
+	conn, err := dialer.DialContext(req.Context(), "unix", t.path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to dial ipc %s, err:%w", t.path, err)
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-req.Context().Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	if _, err = conn.Write(append(bytes.TrimSpace(reqData), '\n')); err != nil {
+		return nil, err
+	}
+	var respData json.RawMessage
+	if err = json.NewDecoder(conn).Decode(&respData); err != nil {
+		return nil, fmt.Errorf("fail to read ipc response, err:%w", err)
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}, "Content-Length": {strconv.Itoa(len(respData))}},
+		Body:          ioutil.NopCloser(bytes.NewReader(respData)),
+		ContentLength: int64(len(respData)),
+		Request:       req,
+	}, nil
+}
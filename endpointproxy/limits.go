@@ -0,0 +1,186 @@
+package endpointproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/celer-network/goutils/log"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	MethodEthGetLogs = "eth_getLogs"
+
+	// same as the http request limit of geth
+	defaultMaxBodyBytes = 5 * 1024 * 1024
+
+	blockTagEarliest = "earliest"
+)
+
+// LimitConfig bounds the requests, zero means the default for MaxBodyBytes and no limit for the others
+type LimitConfig struct {
+	// MaxBodyBytes is the max size of the request body, default is 5MB
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// MaxBatchSize is the max number of calls in a batch request
+	MaxBatchSize int `json:"max_batch_size"`
+	// MaxGetLogsRange is the max number of blocks queried by an eth_getLogs call, the block tags other than
+	// "earliest" and the missing bounds are resolved as the head block, which takes one more upstream call,
+	// the calls are rejected if the head is unknown
+	MaxGetLogsRange uint64 `json:"max_get_logs_range"`
+}
+
+func (c *LimitConfig) maxBodyBytes() int64 {
+	if c == nil || c.MaxBodyBytes <= 0 {
+		return defaultMaxBodyBytes
+	}
+	return c.MaxBodyBytes
+}
+
+// filterArg is the filter object of eth_getLogs
+type filterArg struct {
+	BlockHash *string `json:"blockHash"`
+	FromBlock *string `json:"fromBlock"`
+	ToBlock   *string `json:"toBlock"`
+}
+
+// parseBlockNumber parses the hex block number or "earliest", ok is false for the other tags like "latest"
+func parseBlockNumber(s *string) (uint64, bool) {
+	if s == nil {
+		return 0, false
+	}
+	if *s == blockTagEarliest {
+		return 0, true
+	}
+	n, err := hexutil.DecodeUint64(*s)
+	return n, err == nil
+}
+
+// getLogsRange returns the block range of the eth_getLogs params, ok is false if it is unknown without the head block
+func getLogsRange(params json.RawMessage) (from, to uint64, ok bool) {
+	var args []filterArg
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 || args[0].BlockHash != nil {
+		return 0, 0, false
+	}
+	from, fromOk := parseBlockNumber(args[0].FromBlock)
+	to, toOk := parseBlockNumber(args[0].ToBlock)
+	return from, to, fromOk && toOk
+}
+
+// resolveGetLogsRange returns the block range of the eth_getLogs params with the tags resolved as the head block,
+// which is only fetched by head() if one bound is a number, ok is false for the calls by block hash and the invalid
+// params, which are left to the upstream
+func resolveGetLogsRange(params json.RawMessage, head func() (uint64, error)) (from, to uint64, ok bool, err error) {
+	var args []filterArg
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 || args[0].BlockHash != nil {
+		return 0, 0, false, nil
+	}
+	from, fromOk := parseBlockNumber(args[0].FromBlock)
+	to, toOk := parseBlockNumber(args[0].ToBlock)
+	if !fromOk && !toOk {
+		// both are the head
+		return 0, 0, true, nil
+	}
+	if !fromOk || !toOk {
+		number, err := head()
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if !fromOk {
+			from = number
+		} else {
+			to = number
+		}
+	}
+	return from, to, true, nil
+}
+
+// limitHandler rejects the requests over the limits with json rpc invalid request error, the requests which can't be
+// parsed are rejected too if the batch size or the eth_getLogs range is limited
+func limitHandler(cfg *LimitConfig, chainId uint64, next http.Handler) http.Handler {
+	maxBodyBytes := cfg.maxBodyBytes()
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ContentLength > maxBodyBytes {
+			rejectTooLarge(w, req, chainId, maxBodyBytes)
+			return
+		}
+		var body []byte
+		if req.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(io.LimitReader(req.Body, maxBodyBytes+1))
+			req.Body.Close()
+			if err != nil {
+				writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, "invalid request", nil)
+				return
+			}
+			if int64(len(body)) > maxBodyBytes {
+				rejectTooLarge(w, req, chainId, maxBodyBytes)
+				return
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		if cfg == nil || (cfg.MaxBatchSize <= 0 && cfg.MaxGetLogsRange == 0) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if len(bytes.TrimSpace(body)) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		// the calls of a request which can't be parsed can't be counted or checked
+		msgs, batch, err := parseJsonRpcBody(body)
+		if err != nil {
+			writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, "invalid request", nil)
+			return
+		}
+		if batch && cfg.MaxBatchSize > 0 && len(msgs) > cfg.MaxBatchSize {
+			log.Warnf("reject batch of %d calls, chain:%d, client:%s", len(msgs), chainId, clientIdentity(req))
+			incCounter(1, "limit", "batch", "rejected")
+			writeJsonRpcError(w, http.StatusOK, nil, errCodeInvalidRequest,
+				fmt.Sprintf("batch too large, %d calls, limit is %d", len(msgs), cfg.MaxBatchSize), nil)
+			return
+		}
+		if cfg.MaxGetLogsRange == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		// the head is fetched once for all the calls of a batch
+		head := lazyHeadBlockNumber(req, next)
+		var accepted, rejectedResps []*jsonrpcMessage
+		for _, msg := range msgs {
+			if msg.Method == MethodEthGetLogs {
+				from, to, ok, err := resolveGetLogsRange(msg.Params, head)
+				if err != nil {
+					log.Warnf("reject eth_getLogs of unknown range, chain:%d, client:%s, err:%s", chainId, clientIdentity(req), redact(err.Error()))
+					incCounter(1, "limit", "getlogs", "rejected")
+					rejectedResps = append(rejectedResps, newJsonRpcError(msg.ID, errCodeInvalidParams,
+						"unknown block range, the head block is not available", nil))
+					continue
+				}
+				if ok && to >= from && to-from >= cfg.MaxGetLogsRange {
+					log.Warnf("reject eth_getLogs of blocks %d-%d, chain:%d, client:%s", from, to, chainId, clientIdentity(req))
+					incCounter(1, "limit", "getlogs", "rejected")
+					rejectedResps = append(rejectedResps, newJsonRpcError(msg.ID, errCodeInvalidParams,
+						fmt.Sprintf("block range too large, blocks %d-%d, limit is %d blocks", from, to, cfg.MaxGetLogsRange), nil))
+					continue
+				}
+			}
+			accepted = append(accepted, msg)
+		}
+		if len(rejectedResps) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		serveWithAnswered(w, req, next, batch, accepted, rejectedResps)
+	})
+}
+
+func rejectTooLarge(w http.ResponseWriter, req *http.Request, chainId uint64, maxBodyBytes int64) {
+	log.Warnf("reject request body over %d bytes, chain:%d, client:%s", maxBodyBytes, chainId, clientIdentity(req))
+	incCounter(1, "limit", "body", "rejected")
+	writeJsonRpcError(w, http.StatusRequestEntityTooLarge, nil, errCodeInvalidRequest,
+		fmt.Sprintf("request body too large, limit is %d bytes", maxBodyBytes), nil)
+}
@@ -0,0 +1,59 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLimit(t *testing.T) {
+	cfg := &LimitConfig{MaxBodyBytes: 1024, MaxBatchSize: 2, MaxGetLogsRange: 10}
+	tests := []struct {
+		name          string
+		body          string
+		wantForwarded bool
+		wantCode      int
+	}{
+		{"single", `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`, true, 0},
+		{"batch", `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}]`, true, 0},
+		{"empty", ``, true, 0},
+		{"batch too large", `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"},` +
+			`{"jsonrpc":"2.0","id":3,"method":"eth_chainId"}]`, false, errCodeInvalidRequest},
+		{"malformed batch too large", `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"},` +
+			`{"jsonrpc":"2.0","id":3,"method":"eth_chainId"},1]`, false, errCodeInvalidRequest},
+		{"malformed get logs", `[{"jsonrpc":"2.0","id":1,"method":"eth_getLogs","params":[{"fromBlock":"0x0","toBlock":"0x1000"}]},1]`,
+			false, errCodeInvalidRequest},
+		{"invalid version", `{"jsonrpc":2,"id":1,"method":"eth_getLogs","params":[{"fromBlock":"0x0","toBlock":"0x1000"}]}`,
+			false, errCodeInvalidRequest},
+		{"get logs range", `{"jsonrpc":"2.0","id":1,"method":"eth_getLogs","params":[{"fromBlock":"0x0","toBlock":"0x1000"}]}`,
+			false, errCodeInvalidParams},
+		{"body too large", `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":["` + strings.Repeat("0", 1024) + `"]}`,
+			false, errCodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				forwarded = true
+				writeJsonRpcResponse(w, http.StatusOK, &jsonrpcMessage{Version: "2.0", ID: json.RawMessage("1"), Result: json.RawMessage(`"0x1"`)})
+			})
+			rec := httptest.NewRecorder()
+			limitHandler(cfg, 1, next).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+			if forwarded != tt.wantForwarded {
+				t.Fatalf("forwarded = %v, want %v", forwarded, tt.wantForwarded)
+			}
+			if tt.wantCode == 0 {
+				return
+			}
+			var resp jsonrpcMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid response %s: %v", rec.Body.String(), err)
+			}
+			if resp.Error == nil || resp.Error.Code != tt.wantCode {
+				t.Errorf("error = %+v, want code %d", resp.Error, tt.wantCode)
+			}
+		})
+	}
+}
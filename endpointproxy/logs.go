@@ -0,0 +1,111 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// LogPolicy fixes the log objects, which eth client fails to decode as types.Log
+type LogPolicy struct {
+	// Fill sets removed to false if missing, and converts logIndex, transactionIndex and blockNumber given
+	// as decimal numbers or strings to hex
+	Fill bool `json:"fill"`
+	// BlockHash sets the null blockHash of the logs in a block to the hash of the block, it takes one more
+	// upstream call per block, pending logs without blockNumber are not touched
+	BlockHash bool `json:"block_hash"`
+}
+
+func (p *LogPolicy) enabled() bool {
+	return p != nil && (p.Fill || p.BlockHash)
+}
+
+// logQuantityFields are the quantities of a log which some chains return in decimal
+var logQuantityFields = []string{"logIndex", "transactionIndex", "blockNumber"}
+
+// normalize fixes the logs in place, the objects which are not logs, e.g. the block hashes of
+// eth_getFilterChanges, are skipped
+func (p *LogPolicy) normalize(req *http.Request, next http.Handler, logs []jsonObject) error {
+	noBlockHash := make(map[uint64][]jsonObject)
+	for _, l := range logs {
+		if l == nil || l.missing("topics") {
+			continue
+		}
+		if p.Fill {
+			if l.missing("removed") {
+				l.set("removed", false)
+			}
+			for _, field := range logQuantityFields {
+				if n, ok := decimalQuantity(l[field]); ok {
+					l.set(field, hexutil.Uint64(n))
+				}
+			}
+		}
+		if p.BlockHash && l.missing("blockHash") && !l.missing("blockNumber") {
+			var number hexutil.Uint64
+			if n, ok := decimalQuantity(l["blockNumber"]); ok {
+				number = hexutil.Uint64(n)
+			} else if err := json.Unmarshal(l["blockNumber"], &number); err != nil {
+				continue
+			}
+			noBlockHash[uint64(number)] = append(noBlockHash[uint64(number)], l)
+		}
+	}
+	for number, blockLogs := range noBlockHash {
+		result, err := callMethod(req, next, MethodEthGetBlockByNumber, hexutil.Uint64(number), false)
+		if err != nil {
+			return err
+		}
+		var block blockHeader
+		if err = json.Unmarshal(result, &block); err != nil {
+			return err
+		}
+		if block.Hash == "" {
+			continue
+		}
+		for _, l := range blockLogs {
+			l.set("blockHash", block.Hash)
+		}
+	}
+	return nil
+}
+
+// normalizeNotification fixes the log of a logs subscription notification, other notifications are not touched
+func (p *LogPolicy) normalizeNotification(req *http.Request, next http.Handler, msg *jsonrpcMessage) (bool, error) {
+	var params jsonObject
+	if err := json.Unmarshal(msg.Params, &params); err != nil || params.missing("result") {
+		return false, nil
+	}
+	var l jsonObject
+	if json.Unmarshal(params["result"], &l) != nil || l.missing("topics") {
+		return false, nil
+	}
+	before := l.clone()
+	if err := p.normalize(req, next, []jsonObject{l}); err != nil || !l.changed(before) {
+		return false, err
+	}
+	params.set("result", l)
+	data, err := json.Marshal(params)
+	if err != nil {
+		return false, err
+	}
+	msg.Params = data
+	return true, nil
+}
+
+// decimalQuantity parses a quantity given as a json number or a decimal string
+func decimalQuantity(v json.RawMessage) (uint64, bool) {
+	if len(v) == 0 {
+		return 0, false
+	}
+	s := string(v)
+	if s[0] == '"' {
+		if err := json.Unmarshal(v, &s); err != nil || len(s) == 0 || (len(s) > 1 && s[:2] == "0x") {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	return n, err == nil
+}
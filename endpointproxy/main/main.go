@@ -2,20 +2,28 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"net/http"
 
 	"github.com/celer-network/endpoint-proxy/endpointproxy"
 	"github.com/celer-network/goutils/log"
 )
 
 var (
-	port     = flag.Int("p", 10090, "port for proxy")
-	chainId  = flag.Uint64("cid", 1666700000, "chain id")
-	endpoint = flag.String("endpoint", "https://api.s0.b.hmny.io", "origin endpoint url")
+	port        = flag.Int("p", 10090, "port for proxy")
+	chainId     = flag.Uint64("cid", 1666700000, "chain id")
+	endpoint    = flag.String("endpoint", "https://api.s0.b.hmny.io", "origin endpoint url, or ipc:{path} for a geth style ipc endpoint")
+	addr        = flag.String("addr", "", "listen address, host:port or unix:{socket path}, overrides -p, default is all interfaces on -p")
+	tlsCert     = flag.String("tlscert", "", "tls certificate file, enable tls on the listener with -tlskey")
+	tlsKey      = flag.String("tlskey", "", "tls private key file")
+	clientCa    = flag.String("clientca", "", "client ca file, only clients with a certificate signed by it are accepted")
+	config      = flag.String("config", "", "optional json config file, the flags above override the values in it")
+	metricsAddr = flag.String("metricsaddr", "", "optional listen address to serve prometheus metrics on /metrics")
 )
 
 func main() {
 	flag.Parse()
-	if *port <= 0 {
+	if *addr == "" && *port <= 0 {
 		log.Fatalln("invalid port")
 	}
 	if *chainId <= 0 {
@@ -24,10 +32,37 @@ func main() {
 	if *endpoint == "" {
 		log.Fatalln("invalid endpoint")
 	}
+	cfg := new(endpointproxy.ProxyConfig)
+	if *config != "" {
+		var err error
+		if cfg, err = endpointproxy.LoadProxyConfig(*config); err != nil {
+			log.Fatalln(err)
+		}
+	}
 	// initialize a reverse proxy and pass the actual backend server url here
-	err := endpointproxy.StartProxy(*endpoint, *chainId, *port)
+	if *addr != "" {
+		cfg.ListenAddr = *addr
+	} else if cfg.ListenAddr == "" && cfg.Port == 0 {
+		cfg.ListenAddr = fmt.Sprintf(":%d", *port)
+	}
+	if *tlsCert != "" || *tlsKey != "" {
+		cfg.TLS = &endpointproxy.TLSConfig{CertFile: *tlsCert, KeyFile: *tlsKey, ClientCAFile: *clientCa}
+	} else if *clientCa != "" {
+		if cfg.TLS == nil {
+			log.Fatalln("-clientca requires -tlscert and -tlskey")
+		}
+		cfg.TLS.ClientCAFile = *clientCa
+	}
+	err := endpointproxy.StartProxyWithConfig(*endpoint, *chainId, cfg)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", endpointproxy.MetricsHandler())
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, mux))
+		}()
 	}
 	select {}
 }
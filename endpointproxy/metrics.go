@@ -0,0 +1,37 @@
+package endpointproxy
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/metrics/prometheus"
+)
+
+const (
+	metricsPrefix = "endpointproxy"
+)
+
+var (
+	metricsRegistry     = metrics.NewRegistry()
+	invalidMetricsChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// MetricsHandler serves the metrics of all proxies in this process in prometheus text format
+func MetricsHandler() http.Handler {
+	return prometheus.Handler(metricsRegistry)
+}
+
+// metricsName joins the parts into a metrics name, chars not allowed by prometheus are replaced by _
+func metricsName(parts ...string) string {
+	name := metricsPrefix
+	for _, part := range parts {
+		name += "/" + invalidMetricsChars.ReplaceAllString(strings.ToLower(part), "_")
+	}
+	return name
+}
+
+func incCounter(n int64, parts ...string) {
+	metrics.GetOrRegisterCounterForced(metricsName(parts...), metricsRegistry).Inc(n)
+}
@@ -0,0 +1,381 @@
+package endpointproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/celer-network/goutils/log"
+)
+
+// NormalizePolicy fills the fields missing in the responses of some chains, which break the decoding of eth client
+type NormalizePolicy struct {
+	// Receipts applies to eth_getTransactionReceipt and eth_getBlockReceipts
+	Receipts *ReceiptPolicy `json:"receipts"`
+	// Transactions applies to eth_getTransactionByHash, eth_getTransactionByBlockHashAndIndex,
+	// eth_getTransactionByBlockNumberAndIndex and the blocks with full txs
+	Transactions *TxPolicy `json:"transactions"`
+	// Logs applies to eth_getLogs, eth_getFilterLogs, eth_getFilterChanges, the logs subscription notifications
+	// of websockets and the logs of the receipts normalized by Receipts
+	Logs *LogPolicy `json:"logs"`
+	// Headers applies to eth_getBlockByHash and eth_getBlockByNumber
+	Headers *HeaderPolicy `json:"headers"`
+}
+
+// NormalizeConfig replaces the built-in normalize policies, which fill the missing receipt, tx, log and header fields of celo,
+// conflux and zksync, map the celo and zksync txs to the standard ones, and fill the effectiveGasPrice of conflux receipts,
+// the other chains are not normalized without it
+type NormalizeConfig struct {
+	NormalizePolicy
+	// Chains replaces the policy above for the chain ids in it
+	Chains map[uint64]*NormalizePolicy `json:"chains"`
+}
+
+var (
+	celoNormalizePolicy = &NormalizePolicy{
+		Receipts: &ReceiptPolicy{Fill: true},
+		Transactions: &TxPolicy{
+			Strip: []string{"feeCurrency", "gatewayFee", "gatewayFeeRecipient", "ethCompatible"},
+			// the fee currency txs of CIP-42 and CIP-64 carry the dynamic fee fields
+			Types: map[string]string{"0x7b": "0x2", "0x7c": "0x2"},
+			Fill:  true,
+		},
+		Logs:    &LogPolicy{Fill: true},
+		Headers: &HeaderPolicy{Synthesize: true},
+	}
+	// eSpace receipts of older nodes leave out effectiveGasPrice, and their logs leave out removed
+	confluxNormalizePolicy = &NormalizePolicy{
+		Receipts:     &ReceiptPolicy{Fill: true, EffectiveGasPrice: true},
+		Transactions: &TxPolicy{Fill: true},
+		Logs:         &LogPolicy{Fill: true},
+		Headers:      &HeaderPolicy{Synthesize: true},
+	}
+	// zksync era EIP-712 (0x71) and priority (0xff) txs carry the dynamic fee fields
+	zkSyncTxTypes         = map[string]string{"0x71": "0x2", "0xff": "0x2"}
+	zkSyncNormalizePolicy = &NormalizePolicy{
+		Receipts:     &ReceiptPolicy{Fill: true, Types: zkSyncTxTypes},
+		Transactions: &TxPolicy{Types: zkSyncTxTypes, Fill: true},
+		Logs:         &LogPolicy{Fill: true},
+		Headers:      &HeaderPolicy{Synthesize: true},
+	}
+	defaultNormalizePolicies = map[uint64]*NormalizePolicy{
+		celoChainId:          celoNormalizePolicy,
+		celoTestnetChainId:   celoNormalizePolicy,
+		confluxChainId:       confluxNormalizePolicy,
+		zkSyncTestnetChainId: zkSyncNormalizePolicy,
+		zkSyncMainnetChainId: zkSyncNormalizePolicy,
+	}
+)
+
+// policy returns the policy of the chain, nil if the chain is not normalized
+func (c *NormalizeConfig) policy(chainId uint64) *NormalizePolicy {
+	if c == nil {
+		return defaultNormalizePolicies[chainId]
+	}
+	if policy, ok := c.Chains[chainId]; ok {
+		return policy
+	}
+	return &c.NormalizePolicy
+}
+
+func (p *NormalizePolicy) enabled() bool {
+	return p != nil && (p.Receipts.enabled() || p.Transactions.enabled() || p.Logs.enabled() || p.Headers.enabled())
+}
+
+// normalizes tells if the responses of method are changed by the policy
+func (p *NormalizePolicy) normalizes(method string) bool {
+	switch method {
+	case MethodEthGetTransactionReceipt, MethodEthGetBlockReceipts:
+		return p.Receipts.enabled()
+	case MethodEthGetTransactionByHash, MethodEthGetTransactionByBlockHashAndIndex, MethodEthGetTransactionByBlockNumberAndIndex:
+		return p.Transactions.enabled()
+	case MethodEthGetBlockByHash, MethodEthGetBlockByNumber:
+		return p.Transactions.enabled() || p.Headers.enabled()
+	case MethodEthGetLogs, MethodEthGetFilterLogs, MethodEthGetFilterChanges:
+		return p.Logs.enabled()
+	}
+	return false
+}
+
+// normalize returns the normalized result of the call, changed is false if the result is returned as it is
+func (p *NormalizePolicy) normalize(req *http.Request, next http.Handler, chainId uint64, call *jsonrpcMessage, result json.RawMessage) (json.RawMessage, bool, error) {
+	var changed bool
+	var v interface{}
+	switch call.Method {
+	case MethodEthGetTransactionReceipt:
+		var receipt jsonObject
+		if err := json.Unmarshal(result, &receipt); err != nil {
+			return nil, false, err
+		}
+		var err error
+		if changed, err = p.normalizeReceipts(req, next, []jsonObject{receipt}, false); err != nil {
+			return nil, false, err
+		}
+		v = receipt
+	case MethodEthGetBlockReceipts:
+		var receipts []jsonObject
+		if err := json.Unmarshal(result, &receipts); err != nil {
+			return nil, false, err
+		}
+		var err error
+		if changed, err = p.normalizeReceipts(req, next, receipts, true); err != nil {
+			return nil, false, err
+		}
+		v = receipts
+	case MethodEthGetTransactionByHash, MethodEthGetTransactionByBlockHashAndIndex, MethodEthGetTransactionByBlockNumberAndIndex:
+		var tx jsonObject
+		if err := json.Unmarshal(result, &tx); err != nil {
+			return nil, false, err
+		}
+		changed = p.Transactions.normalize(tx, chainId)
+		v = tx
+	case MethodEthGetBlockByHash, MethodEthGetBlockByNumber:
+		var block jsonObject
+		if err := json.Unmarshal(result, &block); err != nil {
+			return nil, false, err
+		}
+		if p.Headers.enabled() {
+			changed = p.Headers.normalize(block)
+		}
+		if p.Transactions.enabled() {
+			txsChanged, err := p.Transactions.normalizeBlockTxs(block, chainId)
+			if err != nil {
+				return nil, false, err
+			}
+			changed = changed || txsChanged
+		}
+		v = block
+	case MethodEthGetLogs, MethodEthGetFilterLogs, MethodEthGetFilterChanges:
+		var items []json.RawMessage
+		if err := json.Unmarshal(result, &items); err != nil {
+			return nil, false, err
+		}
+		// the changes of block and pending tx filters are hashes
+		logs := make([]jsonObject, len(items))
+		for i, item := range items {
+			if json.Unmarshal(item, &logs[i]) != nil {
+				logs[i] = nil
+			}
+		}
+		before := cloneObjects(logs)
+		if err := p.Logs.normalize(req, next, logs); err != nil {
+			return nil, false, err
+		}
+		for i, l := range logs {
+			if l != nil && l.changed(before[i]) {
+				data, err := json.Marshal(l)
+				if err != nil {
+					return nil, false, err
+				}
+				items[i] = data
+				changed = true
+			}
+		}
+		v = items
+	}
+	if !changed {
+		return result, false, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// normalizeReceipts normalizes the receipts and the logs in them by the log policy
+func (p *NormalizePolicy) normalizeReceipts(req *http.Request, next http.Handler, receipts []jsonObject, block bool) (bool, error) {
+	before := cloneObjects(receipts)
+	if err := p.Receipts.normalize(req, next, receipts, block); err != nil {
+		return false, err
+	}
+	changed := false
+	for i, receipt := range receipts {
+		changed = changed || receipt.changed(before[i])
+	}
+	if !p.Logs.enabled() {
+		return changed, nil
+	}
+	for _, receipt := range receipts {
+		var logs []jsonObject
+		if receipt.missing("logs") || json.Unmarshal(receipt["logs"], &logs) != nil || len(logs) == 0 {
+			continue
+		}
+		logsBefore := cloneObjects(logs)
+		if err := p.Logs.normalize(req, next, logs); err != nil {
+			return false, err
+		}
+		for i, l := range logs {
+			if l.changed(logsBefore[i]) {
+				receipt.set("logs", logs)
+				changed = true
+				break
+			}
+		}
+	}
+	return changed, nil
+}
+
+// jsonObject keeps all the fields of a json object, so that the unknown fields of a chain are not lost
+type jsonObject map[string]json.RawMessage
+
+// missing tells if the field is absent or null
+func (o jsonObject) missing(field string) bool {
+	v, ok := o[field]
+	return !ok || string(v) == "null"
+}
+
+func (o jsonObject) set(field string, v interface{}) {
+	o[field], _ = json.Marshal(v)
+}
+
+// clone copies the fields, the values are shared as the normalizers replace them rather than change them in place
+func (o jsonObject) clone() jsonObject {
+	if o == nil {
+		return nil
+	}
+	c := make(jsonObject, len(o))
+	for k, v := range o {
+		c[k] = v
+	}
+	return c
+}
+
+// changed tells if any field is added, removed or set since the clone before
+func (o jsonObject) changed(before jsonObject) bool {
+	if len(o) != len(before) {
+		return true
+	}
+	for k, v := range o {
+		if old, ok := before[k]; !ok || !bytes.Equal(v, old) {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneObjects(objs []jsonObject) []jsonObject {
+	clones := make([]jsonObject, len(objs))
+	for i, o := range objs {
+		clones[i] = o.clone()
+	}
+	return clones
+}
+
+func (o jsonObject) getString(field string) string {
+	var s string
+	json.Unmarshal(o[field], &s)
+	return s
+}
+
+// normalizeResponse normalizes the result of the call in place, and tells if it is changed
+func (p *NormalizePolicy) normalizeResponse(req *http.Request, next http.Handler, chainId uint64, call, resp *jsonrpcMessage) bool {
+	if resp.Error != nil || len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return false
+	}
+	result, changed, err := p.normalize(req, next, chainId, call, resp.Result)
+	if err != nil {
+		log.Warnf("fail to normalize %s response, chain:%d, err:%s", call.Method, chainId, err.Error())
+		return false
+	}
+	resp.Result = result
+	return changed
+}
+
+// normalizeWebsocket normalizes the responses of the calls and the logs notifications of a websocket
+func normalizeWebsocket(policy *NormalizePolicy, chainId uint64, w http.ResponseWriter, req *http.Request, next http.Handler) http.ResponseWriter {
+	callReq := wsCallRequest(req)
+	var lock sync.Mutex
+	calls := make(map[string]*jsonrpcMessage)
+	fromClient := func(data []byte, reply func([]byte)) []byte {
+		msgs, _, err := parseJsonRpcBody(data)
+		if err != nil {
+			return data
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for _, msg := range msgs {
+			// the calls never answered are not kept over the limit
+			if len(msg.ID) > 0 && string(msg.ID) != "null" && policy.normalizes(msg.Method) && len(calls) < wsMaxPendingCalls {
+				calls[string(msg.ID)] = msg
+			}
+		}
+		return data
+	}
+	toClient := func(data []byte, reply func([]byte)) []byte {
+		msgs, batch, err := parseJsonRpcBody(data)
+		if err != nil {
+			return data
+		}
+		changed := false
+		for _, msg := range msgs {
+			if msg.Method == MethodEthSubscription {
+				if !policy.Logs.enabled() {
+					continue
+				}
+				notified, err := policy.Logs.normalizeNotification(callReq, next, msg)
+				if err != nil {
+					log.Warnf("fail to normalize %s notification, chain:%d, err:%s", msg.Method, chainId, err.Error())
+				}
+				changed = changed || notified
+				continue
+			}
+			lock.Lock()
+			call, ok := calls[string(msg.ID)]
+			delete(calls, string(msg.ID))
+			lock.Unlock()
+			if ok && policy.normalizeResponse(callReq, next, chainId, call, msg) {
+				changed = true
+			}
+		}
+		if !changed {
+			return data
+		}
+		var out []byte
+		if batch {
+			out, err = json.Marshal(msgs)
+		} else {
+			out, err = json.Marshal(msgs[0])
+		}
+		if err != nil {
+			return data
+		}
+		return out
+	}
+	return interceptWebsocket(w, req, fromClient, toClient)
+}
+
+// normalizeHandler changes the results of the calls normalized by the policy, other responses are not touched
+func normalizeHandler(policy *NormalizePolicy, chainId uint64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isWebsocketUpgrade(req) {
+			next.ServeHTTP(normalizeWebsocket(policy, chainId, w, req, next), req)
+			return
+		}
+		body, err := readReqBody(req)
+		if err != nil {
+			writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, "invalid request", nil)
+			return
+		}
+		msgs, batch, err := parseJsonRpcBody(body)
+		if err != nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		calls := make(map[string]*jsonrpcMessage)
+		for _, msg := range msgs {
+			if len(msg.ID) > 0 && string(msg.ID) != "null" && policy.normalizes(msg.Method) {
+				calls[string(msg.ID)] = msg
+			}
+		}
+		if len(calls) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		serveRewritten(w, req, next, msgs, batch, func(resp *jsonrpcMessage) bool {
+			call, ok := calls[string(resp.ID)]
+			return ok && policy.normalizeResponse(req, next, chainId, call, resp)
+		})
+	})
+}
@@ -0,0 +1,58 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizeConfigPolicy(t *testing.T) {
+	var cfg *NormalizeConfig
+	if policy := cfg.policy(1); policy.enabled() {
+		t.Errorf("chain 1 is normalized without config")
+	}
+	for _, chainId := range []uint64{celoChainId, confluxChainId, zkSyncMainnetChainId} {
+		if policy := cfg.policy(chainId); !policy.enabled() {
+			t.Errorf("chain %d is not normalized without config", chainId)
+		}
+	}
+}
+
+func TestNormalizeChanged(t *testing.T) {
+	policy := defaultNormalizePolicies[celoChainId]
+	tests := []struct {
+		name        string
+		method      string
+		result      string
+		wantChanged bool
+	}{
+		{"complete receipt", MethodEthGetTransactionReceipt,
+			`{"type":"0x0","logs":[],"logsBloom":"0x00","cumulativeGasUsed":"0x5208","gasUsed":"0x5208","status":"0x1"}`, false},
+		{"receipt without type", MethodEthGetTransactionReceipt,
+			`{"logs":[],"logsBloom":"0x00","cumulativeGasUsed":"0x5208","gasUsed":"0x5208","status":"0x1"}`, true},
+		{"complete log", MethodEthGetLogs,
+			`[{"topics":[],"removed":false,"logIndex":"0x1","transactionIndex":"0x0","blockNumber":"0x10"}]`, false},
+		{"log without removed", MethodEthGetLogs, `[{"topics":[],"logIndex":"0x1"}]`, true},
+		{"filter hashes", MethodEthGetFilterChanges, `["0x01"]`, false},
+		{"block of tx hashes", MethodEthGetBlockByNumber, `{"number":"0x1","transactions":["0x01"]}`, false},
+		{"block with celo tx", MethodEthGetBlockByNumber,
+			`{"number":"0x1","transactions":[{"type":"0x0","feeCurrency":null,"input":"0x","value":"0x0","v":"0x1","r":"0x1","s":"0x1"}]}`, true},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := &jsonrpcMessage{Method: tt.method}
+			result, changed, err := policy.normalize(req, http.NotFoundHandler(), celoChainId, call, json.RawMessage(tt.result))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v, result %s", changed, tt.wantChanged, result)
+			}
+			if !changed && string(result) != tt.result {
+				t.Errorf("unchanged result %s, want %s", result, tt.result)
+			}
+		})
+	}
+}
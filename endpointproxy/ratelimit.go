@@ -0,0 +1,220 @@
+package endpointproxy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/celer-network/goutils/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	errCodeLimitExceeded = -32005
+
+	// any method without its own limit in RateLimitConfig.PerMethod
+	anyMethod = "*"
+
+	limiterIdleTimeout   = 10 * time.Minute
+	upstreamLimitMaxWait = time.Second
+)
+
+var errUpstreamLimitExceeded = errors.New("upstream rate limit exceeded")
+
+// RateLimit is a token bucket, Rate is the tokens added per second and Burst is the bucket size
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// RateLimitConfig limits the requests of each client, each call in a batch takes one token
+type RateLimitConfig struct {
+	// PerClient limits the calls of each client identity
+	PerClient *RateLimit `json:"per_client"`
+	// PerMethod limits the calls of each client by json rpc method, "*" applies to the methods not listed
+	PerMethod map[string]*RateLimit `json:"per_method"`
+	// Upstream limits the calls sent to the upstream by this proxy, calls wait at most 1 second for a token
+	Upstream *RateLimit `json:"upstream"`
+}
+
+func (l *RateLimit) validate(name string) error {
+	if l == nil {
+		return nil
+	}
+	if l.Rate <= 0 {
+		return fmt.Errorf("invalid rate limit %s, rate %v must be positive", name, l.Rate)
+	}
+	if l.Burst < 1 {
+		return fmt.Errorf("invalid rate limit %s, burst %d must be at least 1", name, l.Burst)
+	}
+	return nil
+}
+
+// validate rejects the limits without rate or burst, which would reject all the calls
+func (c *RateLimitConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if err := c.PerClient.validate("per_client"); err != nil {
+		return err
+	}
+	for method, limit := range c.PerMethod {
+		if err := limit.validate("per_method " + method); err != nil {
+			return err
+		}
+	}
+	return c.Upstream.validate("upstream")
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// limiterSet keeps a limiter per key, idle limiters are removed
+type limiterSet struct {
+	limit *RateLimit
+
+	lock      sync.Mutex
+	limiters  map[string]*limiterEntry
+	lastPrune time.Time
+}
+
+func newLimiterSet(limit *RateLimit) *limiterSet {
+	return &limiterSet{limit: limit, limiters: make(map[string]*limiterEntry), lastPrune: time.Now()}
+}
+
+func (s *limiterSet) get(key string) *rate.Limiter {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastPrune) > limiterIdleTimeout {
+		for k, e := range s.limiters {
+			if now.Sub(e.lastSeen) > limiterIdleTimeout {
+				delete(s.limiters, k)
+			}
+		}
+		s.lastPrune = now
+	}
+	e, ok := s.limiters[key]
+	if !ok {
+		e = &limiterEntry{limiter: newLimiter(s.limit)}
+		s.limiters[key] = e
+	}
+	e.lastSeen = now
+	return e.limiter
+}
+
+func newLimiter(limit *RateLimit) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)
+}
+
+// rateLimitHandler rejects the calls over the limits with json rpc limit exceeded error and a retry hint
+func rateLimitHandler(cfg *RateLimitConfig, chainId uint64, next http.Handler) http.Handler {
+	var clientLimiters *limiterSet
+	if cfg.PerClient != nil {
+		clientLimiters = newLimiterSet(cfg.PerClient)
+	}
+	methodLimiters := make(map[string]*limiterSet)
+	for method, limit := range cfg.PerMethod {
+		methodLimiters[method] = newLimiterSet(limit)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := readReqBody(req)
+		if err != nil {
+			writeJsonRpcError(w, http.StatusBadRequest, nil, errCodeInvalidRequest, "invalid request", nil)
+			return
+		}
+		// the upstream reports the parse error of an invalid body, it is still limited as a single call
+		msgs, batch, _ := parseJsonRpcBody(body)
+		identity := clientIdentity(req)
+		now := time.Now()
+		var reservations []*rate.Reservation
+		reserve := func(l *rate.Limiter, n int) {
+			reservations = append(reservations, l.ReserveN(now, n))
+		}
+		if clientLimiters != nil {
+			n := len(msgs)
+			if n == 0 {
+				n = 1
+			}
+			reserve(clientLimiters.get(identity), n)
+		}
+		methodCount := make(map[string]int)
+		for _, msg := range msgs {
+			method := msg.Method
+			if _, ok := methodLimiters[method]; !ok {
+				method = anyMethod
+			}
+			methodCount[method]++
+		}
+		for method, n := range methodCount {
+			if set, ok := methodLimiters[method]; ok {
+				reserve(set.get(identity+"/"+method), n)
+			}
+		}
+		var retryAfter time.Duration
+		tooManyCalls := false
+		for _, r := range reservations {
+			if !r.OK() {
+				// more calls than the burst, they can never pass in one request
+				tooManyCalls = true
+			} else if d := r.DelayFrom(now); d > retryAfter {
+				retryAfter = d
+			}
+		}
+		if !tooManyCalls && retryAfter == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+		log.Warnf("rate limit exceeded, chain:%d, client:%s", chainId, identity)
+		incCounter(1, "ratelimit", "client", "rejected")
+		if tooManyCalls {
+			writeJsonRpcErrors(w, http.StatusTooManyRequests, msgs, batch, errCodeLimitExceeded, "limit exceeded, too many calls in one request", nil)
+			return
+		}
+		writeLimitExceeded(w, msgs, batch, retryAfter)
+	})
+}
+
+func writeLimitExceeded(w http.ResponseWriter, msgs []*jsonrpcMessage, batch bool, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeJsonRpcErrors(w, http.StatusTooManyRequests, msgs, batch, errCodeLimitExceeded, "limit exceeded",
+		map[string]int64{"retry_after_ms": retryAfter.Milliseconds()})
+}
+
+// upstreamLimitTransport limits the requests sent to the upstream
+type upstreamLimitTransport struct {
+	limiter *rate.Limiter
+	base    http.RoundTripper
+}
+
+func (t *upstreamLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := t.limiter.Reserve()
+	if !r.OK() {
+		return nil, errUpstreamLimitExceeded
+	}
+	delay := r.Delay()
+	if delay > upstreamLimitMaxWait {
+		r.Cancel()
+		return nil, errUpstreamLimitExceeded
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			r.Cancel()
+			return nil, req.Context().Err()
+		}
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,66 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRateLimitConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *RateLimitConfig
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"valid", &RateLimitConfig{PerClient: &RateLimit{Rate: 10, Burst: 20}, Upstream: &RateLimit{Rate: 0.5, Burst: 1}}, false},
+		{"zero rate", &RateLimitConfig{PerClient: &RateLimit{Burst: 20}}, true},
+		{"negative rate", &RateLimitConfig{Upstream: &RateLimit{Rate: -1, Burst: 1}}, true},
+		{"zero burst", &RateLimitConfig{PerMethod: map[string]*RateLimit{"eth_call": {Rate: 10}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	cfg := &RateLimitConfig{
+		PerClient: &RateLimit{Rate: 0.001, Burst: 3},
+		PerMethod: map[string]*RateLimit{"eth_call": {Rate: 0.001, Burst: 1}},
+	}
+	forwarded := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		forwarded++
+		writeJsonRpcResponse(w, http.StatusOK, &jsonrpcMessage{Version: "2.0", ID: json.RawMessage("1"), Result: json.RawMessage(`"0x1"`)})
+	})
+	handler := rateLimitHandler(cfg, 1, next)
+	tests := []struct {
+		name          string
+		body          string
+		wantForwarded bool
+	}{
+		{"first call", `{"jsonrpc":"2.0","id":1,"method":"eth_call"}`, true},
+		{"method limit", `{"jsonrpc":"2.0","id":1,"method":"eth_call"}`, false},
+		{"other method", `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`, true},
+		{"client limit", `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}]`, false},
+	}
+	for _, tt := range tests {
+		forwarded = 0
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+		if (forwarded > 0) != tt.wantForwarded {
+			t.Errorf("%s: forwarded = %v, want %v", tt.name, forwarded > 0, tt.wantForwarded)
+		}
+		if !tt.wantForwarded {
+			if rec.Header().Get("Retry-After") == "" || !strings.Contains(rec.Body.String(), "-32005") {
+				t.Errorf("%s: response %s without limit exceeded error", tt.name, rec.Body.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ReceiptPolicy fills the missing fields of receipts, the fields returned by the upstream are never changed
+type ReceiptPolicy struct {
+	// Fill sets the missing fields derived from the receipts themselves, i.e. type as legacy, empty logs,
+	// logsBloom from the logs, and cumulativeGasUsed from the gasUsed of the receipts before it in the block.
+	// For a single receipt not first in its block, cumulativeGasUsed takes one more upstream call for the receipts
+	// of the block, and is left out if it fails.
+	Fill bool `json:"fill"`
+	// DefaultStatus is the status of the receipts with neither status nor root, e.g. "0x1" for the chains which
+	// only omit the status of successful txs
+	DefaultStatus *hexutil.Uint64 `json:"default_status"`
+	// EffectiveGasPrice sets the missing effectiveGasPrice to the gasPrice of the tx, it takes one more upstream call
+	EffectiveGasPrice bool `json:"effective_gas_price"`
+	// Types maps the receipt types the same way as TxPolicy.Types, so that the receipts match their txs
+	Types map[string]string `json:"types"`
+}
+
+func (p *ReceiptPolicy) enabled() bool {
+	return p != nil && (p.Fill || p.DefaultStatus != nil || p.EffectiveGasPrice || len(p.Types) > 0)
+}
+
+// receiptLog is the part of a log used by the bloom
+type receiptLog struct {
+	Address common.Address `json:"address"`
+	Topics  []common.Hash  `json:"topics"`
+}
+
+// normalize fills the receipts in place, block is true if they are all the receipts of a block in order
+func (p *ReceiptPolicy) normalize(req *http.Request, next http.Handler, receipts []jsonObject, block bool) error {
+	var cumulativeGasUsed uint64
+	var noGasPrice []jsonObject
+	for _, receipt := range receipts {
+		var gasUsed hexutil.Uint64
+		json.Unmarshal(receipt["gasUsed"], &gasUsed)
+		cumulativeGasUsed += uint64(gasUsed)
+		if receiptType, ok := p.Types[receipt.getString("type")]; ok {
+			receipt.set("type", receiptType)
+		}
+		if p.Fill {
+			if receipt.missing("type") {
+				receipt.set("type", hexutil.Uint64(types.LegacyTxType))
+			}
+			if receipt.missing("logs") {
+				receipt.set("logs", []struct{}{})
+			}
+			if receipt.missing("logsBloom") {
+				var logs []receiptLog
+				if err := json.Unmarshal(receipt["logs"], &logs); err != nil {
+					return err
+				}
+				var bloom types.Bloom
+				for _, l := range logs {
+					bloom.Add(l.Address.Bytes())
+					for _, topic := range l.Topics {
+						bloom.Add(topic.Bytes())
+					}
+				}
+				receipt.set("logsBloom", bloom)
+			}
+			if receipt.missing("cumulativeGasUsed") {
+				if block {
+					receipt.set("cumulativeGasUsed", hexutil.Uint64(cumulativeGasUsed))
+				} else if cumulative, ok := blockCumulativeGasUsed(req, next, receipt, gasUsed); ok {
+					receipt.set("cumulativeGasUsed", cumulative)
+				}
+			}
+		}
+		if p.DefaultStatus != nil && receipt.missing("status") && receipt.missing("root") {
+			receipt.set("status", p.DefaultStatus)
+		}
+		if p.EffectiveGasPrice && receipt.missing("effectiveGasPrice") {
+			noGasPrice = append(noGasPrice, receipt)
+		}
+	}
+	if len(noGasPrice) == 0 {
+		return nil
+	}
+	gasPrices, err := txGasPrices(req, next, noGasPrice, block)
+	if err != nil {
+		return err
+	}
+	for _, receipt := range noGasPrice {
+		if gasPrice, ok := gasPrices[receipt.getString("transactionHash")]; ok {
+			receipt["effectiveGasPrice"] = gasPrice
+		}
+	}
+	return nil
+}
+
+// blockCumulativeGasUsed returns the cumulativeGasUsed of a single receipt, from the receipts of its block
+// unless it is the first one
+func blockCumulativeGasUsed(req *http.Request, next http.Handler, receipt jsonObject, gasUsed hexutil.Uint64) (hexutil.Uint64, bool) {
+	var index hexutil.Uint64
+	if err := json.Unmarshal(receipt["transactionIndex"], &index); err != nil {
+		return 0, false
+	}
+	if index == 0 {
+		return gasUsed, true
+	}
+	result, err := callMethod(req, next, MethodEthGetBlockReceipts, receipt.getString("blockHash"))
+	if err != nil {
+		return 0, false
+	}
+	var receipts []struct {
+		TransactionHash   string          `json:"transactionHash"`
+		GasUsed           hexutil.Uint64  `json:"gasUsed"`
+		CumulativeGasUsed *hexutil.Uint64 `json:"cumulativeGasUsed"`
+	}
+	if err = json.Unmarshal(result, &receipts); err != nil {
+		return 0, false
+	}
+	var cumulativeGasUsed hexutil.Uint64
+	for _, r := range receipts {
+		cumulativeGasUsed += r.GasUsed
+		if r.TransactionHash != receipt.getString("transactionHash") {
+			continue
+		}
+		if r.CumulativeGasUsed != nil {
+			return *r.CumulativeGasUsed, true
+		}
+		return cumulativeGasUsed, true
+	}
+	return 0, false
+}
+
+// txGasPrices gets the gasPrice of the txs of the receipts by tx hash, the txs of block receipts are fetched
+// with the block in one call
+func txGasPrices(req *http.Request, next http.Handler, receipts []jsonObject, block bool) (map[string]json.RawMessage, error) {
+	var txs []jsonObject
+	if block {
+		result, err := callMethod(req, next, MethodEthGetBlockByHash, receipts[0].getString("blockHash"), true)
+		if err != nil {
+			return nil, err
+		}
+		var b struct {
+			Transactions []jsonObject `json:"transactions"`
+		}
+		if err = json.Unmarshal(result, &b); err != nil {
+			return nil, err
+		}
+		txs = b.Transactions
+	} else {
+		result, err := callMethod(req, next, MethodEthGetTransactionByHash, receipts[0].getString("transactionHash"))
+		if err != nil {
+			return nil, err
+		}
+		var tx jsonObject
+		if err = json.Unmarshal(result, &tx); err != nil {
+			return nil, err
+		}
+		txs = []jsonObject{tx}
+	}
+	gasPrices := make(map[string]json.RawMessage)
+	for _, tx := range txs {
+		if tx != nil && !tx.missing("gasPrice") {
+			gasPrices[tx.getString("hash")] = tx["gasPrice"]
+		}
+	}
+	return gasPrices, nil
+}
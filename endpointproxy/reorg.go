@@ -0,0 +1,201 @@
+package endpointproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/celer-network/goutils/log"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	defaultReorgWindow = 128
+	reorgPollTimeout   = 10 * time.Second
+)
+
+// blockHeader is the part of a block used to detect reorgs
+type blockHeader struct {
+	Number     hexutil.Uint64 `json:"number"`
+	Hash       string         `json:"hash"`
+	ParentHash string         `json:"parentHash"`
+}
+
+// reorgTracker keeps the hashes of the recent blocks and the cache keys of the responses in them,
+// the responses of reorged blocks are evicted from the cache
+type reorgTracker struct {
+	c        *responseCache
+	interval time.Duration
+	window   uint64
+	// 1 while a poll is running
+	polling int32
+
+	lock     sync.Mutex
+	lastPoll time.Time
+	head     uint64
+	hashes   map[uint64]string
+	keys     map[uint64][]string
+}
+
+func newReorgTracker(c *responseCache, cfg *CacheConfig) *reorgTracker {
+	window := cfg.ReorgWindow
+	if window == 0 {
+		window = defaultReorgWindow
+	}
+	return &reorgTracker{
+		c:        c,
+		interval: time.Duration(cfg.ReorgPollMs) * time.Millisecond,
+		window:   window,
+		hashes:   make(map[uint64]string),
+		keys:     make(map[uint64][]string),
+	}
+}
+
+// maybePoll starts polling the head in background if the last poll is older than the interval,
+// the head is only polled while the proxy is in use
+func (t *reorgTracker) maybePoll(next http.Handler) {
+	t.lock.Lock()
+	due := time.Since(t.lastPoll) >= t.interval
+	t.lock.Unlock()
+	if !due || !atomic.CompareAndSwapInt32(&t.polling, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&t.polling, 0)
+		t.poll(next)
+		t.lock.Lock()
+		t.lastPoll = time.Now()
+		t.lock.Unlock()
+	}()
+}
+
+func (t *reorgTracker) fetchBlock(next http.Handler, method string, param string) (*blockHeader, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), reorgPollTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = inProcessAddr
+	result, err := callMethod(req, next, method, param, false)
+	if err != nil {
+		return nil, err
+	}
+	block := new(blockHeader)
+	if err = json.Unmarshal(result, block); err != nil || block.Hash == "" {
+		return nil, fmt.Errorf("invalid block %s", result)
+	}
+	return block, nil
+}
+
+// poll gets the head block, and walks back by parent hash until a tracked hash matches or out of the window,
+// the tracked blocks with different hashes are reorged
+func (t *reorgTracker) poll(next http.Handler) {
+	head, err := t.fetchBlock(next, MethodEthGetBlockByNumber, "latest")
+	if err != nil {
+		log.Warnf("fail to poll head for reorgs, chain:%d, err:%s", t.c.chainId, redact(err.Error()))
+		return
+	}
+	t.lock.Lock()
+	oldHead := t.head
+	t.lock.Unlock()
+
+	// the first poll fetches the whole window, later ones only the new blocks unless there is a reorg
+	var reorged []uint64
+	newHashes := make(map[uint64]string)
+	for b := head; ; {
+		number := uint64(b.Number)
+		t.lock.Lock()
+		tracked, ok := t.hashes[number]
+		t.lock.Unlock()
+		if ok && tracked == b.Hash {
+			break
+		}
+		if ok {
+			reorged = append(reorged, number)
+		}
+		newHashes[number] = b.Hash
+		if number == 0 || number+t.window <= uint64(head.Number) {
+			break
+		}
+		if b, err = t.fetchBlock(next, MethodEthGetBlockByHash, b.ParentHash); err != nil {
+			log.Warnf("fail to get parent block for reorgs, chain:%d, err:%s", t.c.chainId, redact(err.Error()))
+			break
+		}
+	}
+
+	t.lock.Lock()
+	for number, hash := range newHashes {
+		t.hashes[number] = hash
+	}
+	if len(reorged) > 0 {
+		// the blocks above the new head are gone as well
+		for number := uint64(head.Number) + 1; number <= oldHead; number++ {
+			if _, ok := t.hashes[number]; ok {
+				reorged = append(reorged, number)
+				delete(t.hashes, number)
+			}
+		}
+	}
+	// a lagging upstream node may report an older head, it is only moved back by a reorg
+	if uint64(head.Number) > t.head || len(reorged) > 0 {
+		t.head = uint64(head.Number)
+	}
+	var evicted []string
+	for _, number := range reorged {
+		evicted = append(evicted, t.keys[number]...)
+		delete(t.keys, number)
+	}
+	t.prune()
+	t.lock.Unlock()
+
+	t.c.head.set(uint64(head.Number))
+	if len(reorged) == 0 {
+		return
+	}
+	log.Warnf("reorg detected, chain:%d, head:%d, reorged blocks:%d, evicted responses:%d",
+		t.c.chainId, head.Number, len(reorged), len(evicted))
+	incCounter(1, "cache", "reorg", "detected")
+	incCounter(int64(len(reorged)), "cache", "reorg", "blocks")
+	t.c.evict(evicted)
+}
+
+// prune forgets the blocks out of the window, it is called with the lock held
+func (t *reorgTracker) prune() {
+	if t.head < t.window {
+		return
+	}
+	for number := range t.hashes {
+		if number+t.window <= t.head {
+			delete(t.hashes, number)
+		}
+	}
+	for number := range t.keys {
+		if number+t.window <= t.head {
+			delete(t.keys, number)
+		}
+	}
+}
+
+// track records the cache key of a response in the blocks from to to, only the blocks in the window are tracked,
+// it returns false if the blocks are out of the window
+func (t *reorgTracker) track(key string, from, to uint64) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if to+t.window <= t.head {
+		return false
+	}
+	// the blocks are not above the head, so only the last window blocks of the range can be reorged
+	if to >= from && to-from >= t.window {
+		from = to - t.window + 1
+	}
+	for number := from; number <= to; number++ {
+		t.keys[number] = append(t.keys[number], key)
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package endpointproxy
+
+import "testing"
+
+func TestReorgTrackerTrack(t *testing.T) {
+	tracker := newReorgTracker(nil, &CacheConfig{ReorgPollMs: 1000, ReorgWindow: 10})
+	tracker.head = 100
+	tests := []struct {
+		name        string
+		from, to    uint64
+		wantTracked bool
+		// the first and last tracked blocks, and the number of them
+		wantFirst, wantLast uint64
+		wantBlocks          int
+	}{
+		{"in window", 95, 95, true, 95, 95, 1},
+		{"out of window", 80, 90, false, 0, 0, 0},
+		{"range over window", 60, 92, true, 83, 92, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker.keys = make(map[uint64][]string)
+			if tracked := tracker.track(tt.name, tt.from, tt.to); tracked != tt.wantTracked {
+				t.Errorf("track(%d, %d) = %v, want %v", tt.from, tt.to, tracked, tt.wantTracked)
+			}
+			if len(tracker.keys) != tt.wantBlocks {
+				t.Fatalf("tracked %d blocks, want %d", len(tracker.keys), tt.wantBlocks)
+			}
+			if tt.wantBlocks > 0 && (len(tracker.keys[tt.wantFirst]) != 1 || len(tracker.keys[tt.wantLast]) != 1) {
+				t.Errorf("blocks %d-%d are not tracked", tt.wantFirst, tt.wantLast)
+			}
+		})
+	}
+}
@@ -17,11 +17,11 @@ type SxProxy struct {
 }
 
 // NewProxy takes target host and creates a reverse proxy
-func (h *SxProxy) startSxProxy(targetHost string, port int, chainId uint64) error {
+func (h *SxProxy) newSxProxy(targetHost string) (*httputil.ReverseProxy, error) {
 	var err error
 	h.sxTargetUrl, err = url.Parse(targetHost)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	p := httputil.NewSingleHostReverseProxy(h.sxTargetUrl)
 	originalDirector := p.Director
@@ -29,10 +29,7 @@ func (h *SxProxy) startSxProxy(targetHost string, port int, chainId uint64) erro
 		originalDirector(req)
 		h.modifySxRequest(req)
 	}
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", proxyRequestHandler(p))
-	go startCustomProxyByPort(port, mux, chainId, targetHost)
-	return nil
+	return p, nil
 }
 
 func (h *SxProxy) modifySxRequest(req *http.Request) {
@@ -47,6 +44,8 @@ func (h *SxProxy) modifySxRequest(req *http.Request) {
 	msg := &jsonrpcMessage{}
 	if err = json.Unmarshal(reqStr, msg); err != nil {
 		log.Errorf("fail to unmarshal this sx req body err:%s", err.Error())
+		// e.g. batch request, forward it as it is
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqStr))
 		return
 	}
 	if msg.Method == MethodEthGetCode {
@@ -0,0 +1,122 @@
+package endpointproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/celer-network/goutils/log"
+)
+
+const (
+	tlsReloadCheckInterval = 5 * time.Second
+)
+
+// TLSConfig enables TLS on the proxy listener
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// ClientCAFile is optional, if set only clients with a certificate signed by these CAs are accepted
+	ClientCAFile string `json:"client_ca_file"`
+}
+
+// tlsReloader serves the certificate and client CAs from files and reloads them once the files change
+type tlsReloader struct {
+	cfg *TLSConfig
+
+	lock      sync.Mutex
+	current   *tls.Config
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+func newTLSReloader(cfg *TLSConfig) (*tlsReloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("tls cert file and key file are required")
+	}
+	r := &tlsReloader{cfg: cfg}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *tlsReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+func (r *tlsReloader) load() error {
+	var modTimes []time.Time
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, fi.ModTime())
+	}
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	current := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if r.cfg.ClientCAFile != "" {
+		caData, err := ioutil.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return fmt.Errorf("no valid certificate in client ca file %s", r.cfg.ClientCAFile)
+		}
+		current.ClientCAs = pool
+		current.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	r.current = current
+	r.modTimes = modTimes
+	return nil
+}
+
+func (r *tlsReloader) changed() bool {
+	for i, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return false
+		}
+		if !fi.ModTime().Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if time.Since(r.lastCheck) >= tlsReloadCheckInterval {
+		r.lastCheck = time.Now()
+		if r.changed() {
+			// keep serving the old certificate if the new files are broken, e.g. half written
+			if err := r.load(); err != nil {
+				log.Errorf("fail to reload tls files, err:%s", err.Error())
+			} else {
+				log.Infof("tls files reloaded, cert:%s", r.cfg.CertFile)
+			}
+		}
+	}
+	return r.current, nil
+}
+
+func (r *tlsReloader) tlsConfig() *tls.Config {
+	return &tls.Config{GetConfigForClient: r.getConfigForClient}
+}
@@ -0,0 +1,88 @@
+package endpointproxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// the client identity of in-process requests, used by rate limits and logs
+const inProcessAddr = "in-process"
+
+// proxyTransport serves the requests with the handler of a chain proxy in-process, without any listening port
+type proxyTransport struct {
+	handler http.Handler
+}
+
+// NewTransport returns a http.RoundTripper which applies the same fixups as the proxy server of chainId,
+// and sends every request to endpoint. The url of the request is ignored.
+func NewTransport(chainId uint64, endpoint string) (http.RoundTripper, error) {
+	return NewTransportWithConfig(chainId, endpoint, new(ProxyConfig))
+}
+
+// NewTransportWithConfig is same as NewTransport with the settings of cfg, the listener, TLS, Auth and Cors settings are not used
+func NewTransportWithConfig(chainId uint64, endpoint string, cfg *ProxyConfig) (http.RoundTripper, error) {
+	p, err := newConfiguredProxy(endpoint, chainId, cfg)
+	if err != nil {
+		return nil, err
+	}
+	// the in-process caller is trusted and is not a browser
+	inProcessCfg := *cfg
+	inProcessCfg.Auth = nil
+	inProcessCfg.Cors = nil
+	handler, err := newProxyHandler(p, &inProcessCfg, chainId)
+	if err != nil {
+		return nil, err
+	}
+	return &proxyTransport{handler: handler}, nil
+}
+
+// NewRpcClient returns a rpc client which talks to endpoint through the in-process transport of chainId
+func NewRpcClient(chainId uint64, endpoint string) (*rpc.Client, error) {
+	t, err := NewTransport(chainId, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.DialHTTPWithClient(endpoint, &http.Client{Transport: t})
+}
+
+// NewEthClient returns an eth client which talks to endpoint through the in-process transport of chainId
+func NewEthClient(chainId uint64, endpoint string) (*ethclient.Client, error) {
+	rpcClient, err := NewRpcClient(chainId, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return ethclient.NewClient(rpcClient), nil
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	inReq := req.Clone(req.Context())
+	// director of the reverse proxy joins the target path with the request path, so reset it here
+	inReq.URL = &url.URL{Path: "/"}
+	inReq.RequestURI = "/"
+	inReq.Host = ""
+	inReq.RemoteAddr = inProcessAddr
+	if inReq.Body == nil {
+		inReq.Body = http.NoBody
+	}
+	buf := newResponseBuffer()
+	t.handler.ServeHTTP(buf, inReq)
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", buf.status, http.StatusText(buf.status)),
+		StatusCode:    buf.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        buf.header,
+		Body:          ioutil.NopCloser(&buf.body),
+		ContentLength: int64(buf.body.Len()),
+		Request:       req,
+	}, nil
+}
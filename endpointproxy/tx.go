@@ -0,0 +1,142 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/celer-network/goutils/log"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TxPolicy normalizes the tx objects, the steps are applied in the order of the fields
+type TxPolicy struct {
+	// Rename maps the non-standard field names to the standard ones, e.g. {"data": "input"},
+	// a field is not renamed if the standard one is present
+	Rename map[string]string `json:"rename"`
+	// Strip removes the non-standard fields, e.g. ["feeCurrency", "gatewayFee"]
+	Strip []string `json:"strip"`
+	// Types maps the tx types not supported by eth client to supported ones, e.g. {"0x7c": "0x2"}
+	Types map[string]string `json:"types"`
+	// Fill sets the missing fields, i.e. type as legacy, chainId of typed txs, maxFeePerGas and maxPriorityFeePerGas
+	// of dynamic fee txs from gasPrice, empty input, zero value, and zero v, r and s, which eth client decodes as
+	// a tx without signature instead of failing
+	Fill bool `json:"fill"`
+	// DropTypes removes the txs of the types from the full blocks before the steps above, e.g. ["0xff"],
+	// the tx queries are not changed by it. The transactionsRoot of the blocks still covers the dropped txs, and
+	// eth client rejects a block without txs whose transactionsRoot is not empty, so the txs of a block are kept
+	// if all of them would be dropped. Map the types by Types instead where possible
+	DropTypes []string `json:"drop_types"`
+}
+
+func (p *TxPolicy) enabled() bool {
+	return p != nil && (len(p.Rename) > 0 || len(p.Strip) > 0 || len(p.Types) > 0 || p.Fill || len(p.DropTypes) > 0)
+}
+
+// normalize changes the tx in place, and tells if it is changed
+func (p *TxPolicy) normalize(tx jsonObject, chainId uint64) bool {
+	before := tx.clone()
+	for from, to := range p.Rename {
+		if v, ok := tx[from]; ok && tx.missing(to) {
+			tx[to] = v
+			delete(tx, from)
+		}
+	}
+	for _, field := range p.Strip {
+		delete(tx, field)
+	}
+	if txType, ok := p.Types[tx.getString("type")]; ok {
+		tx.set("type", txType)
+	}
+	if !p.Fill {
+		return tx.changed(before)
+	}
+	if tx.missing("type") {
+		tx.set("type", hexutil.Uint64(types.LegacyTxType))
+	}
+	if tx.missing("chainId") && tx.getString("type") != hexutil.EncodeUint64(types.LegacyTxType) {
+		tx.set("chainId", (*hexutil.Big)(new(big.Int).SetUint64(chainId)))
+	}
+	if tx.getString("type") == hexutil.EncodeUint64(types.DynamicFeeTxType) && !tx.missing("gasPrice") {
+		for _, field := range []string{"maxFeePerGas", "maxPriorityFeePerGas"} {
+			if tx.missing(field) {
+				tx[field] = tx["gasPrice"]
+			}
+		}
+	}
+	if tx.missing("input") {
+		tx.set("input", hexutil.Bytes{})
+	}
+	if tx.missing("value") {
+		tx.set("value", new(hexutil.Big))
+	}
+	for _, field := range []string{"v", "r", "s"} {
+		if tx.missing(field) {
+			tx.set(field, new(hexutil.Big))
+		}
+	}
+	return tx.changed(before)
+}
+
+func (p *TxPolicy) drops(txType string) bool {
+	for _, t := range p.DropTypes {
+		if strings.EqualFold(t, txType) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeBlockTxs normalizes the txs of a block with full txs, tx hashes are not touched. The txs of DropTypes
+// are kept if all the txs of the block are of them
+func (p *TxPolicy) normalizeBlockTxs(block jsonObject, chainId uint64) (bool, error) {
+	if block.missing("transactions") {
+		return false, nil
+	}
+	var txs []json.RawMessage
+	if err := json.Unmarshal(block["transactions"], &txs); err != nil {
+		return false, err
+	}
+	// nil for the tx hashes
+	objs := make([]jsonObject, len(txs))
+	var dropped []string
+	for i, raw := range txs {
+		if json.Unmarshal(raw, &objs[i]) == nil && objs[i] != nil && p.drops(objs[i].getString("type")) {
+			dropped = append(dropped, objs[i].getString("type"))
+		}
+	}
+	// eth client fails on a block without txs whose transactionsRoot is not empty
+	dropping := len(dropped) > 0 && len(dropped) < len(txs)
+	if len(dropped) > 0 && !dropping {
+		log.Warnf("keep the txs of unsupported types %s, all the txs of block %s are of them, chain:%d",
+			strings.Join(dropped, ","), block.getString("number"), chainId)
+	}
+	changed := false
+	kept := txs[:0]
+	for i, raw := range txs {
+		tx := objs[i]
+		if tx == nil {
+			kept = append(kept, raw)
+			continue
+		}
+		if dropping && p.drops(tx.getString("type")) {
+			changed = true
+			continue
+		}
+		if !p.normalize(tx, chainId) {
+			kept = append(kept, raw)
+			continue
+		}
+		data, err := json.Marshal(tx)
+		if err != nil {
+			return false, err
+		}
+		kept = append(kept, data)
+		changed = true
+	}
+	if changed {
+		block.set("transactions", kept)
+	}
+	return changed, nil
+}
@@ -0,0 +1,36 @@
+package endpointproxy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNormalizeBlockTxsDropTypes(t *testing.T) {
+	policy := &TxPolicy{DropTypes: []string{"0x7e"}}
+	tests := []struct {
+		name        string
+		txs         string
+		wantTxs     string
+		wantChanged bool
+	}{
+		{"dropped", `[{"type":"0x7e","hash":"0x01"},{"type":"0x2","hash":"0x02"}]`, `[{"type":"0x2","hash":"0x02"}]`, true},
+		{"upper case type", `[{"type":"0x7E","hash":"0x01"},{"type":"0x2","hash":"0x02"}]`, `[{"type":"0x2","hash":"0x02"}]`, true},
+		{"all dropped", `[{"type":"0x7e","hash":"0x01"},{"type":"0x7e","hash":"0x02"}]`,
+			`[{"type":"0x7e","hash":"0x01"},{"type":"0x7e","hash":"0x02"}]`, false},
+		{"nothing dropped", `[{"type":"0x2","hash":"0x02"}]`, `[{"type":"0x2","hash":"0x02"}]`, false},
+		{"tx hashes", `["0x01","0x02"]`, `["0x01","0x02"]`, false},
+		{"empty", `[]`, `[]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := jsonObject{"number": json.RawMessage(`"0x1"`), "transactions": json.RawMessage(tt.txs)}
+			changed, err := policy.normalizeBlockTxs(block, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.wantChanged || string(block["transactions"]) != tt.wantTxs {
+				t.Errorf("normalizeBlockTxs = %s, %v, want %s, %v", block["transactions"], changed, tt.wantTxs, tt.wantChanged)
+			}
+		})
+	}
+}
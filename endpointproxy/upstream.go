@@ -0,0 +1,226 @@
+package endpointproxy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	redactedSecret = "***"
+	// shorter secrets are not redacted, as they would garble the logs, e.g. the chain ids
+	minSecretLen = 6
+	// path segments at least this long with both letters and digits are treated as api keys in logs
+	minPathKeyLen = 16
+)
+
+var (
+	secretRefRegexp = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+	letterRegexp    = regexp.MustCompile(`[a-zA-Z]`)
+	digitRegexp     = regexp.MustCompile(`[0-9]`)
+	// the query keys of the endpoint url whose values are api keys
+	secretQueryKeys = []string{"apikey", "api_key", "api-key", "key", "token", "access_token", "secret", "password"}
+
+	secretsLock  sync.RWMutex
+	knownSecrets = make(map[string]bool)
+)
+
+// UpstreamConfig injects the credentials of paid rpc providers into the requests to the upstream,
+// the values can refer to secrets by ${env:NAME} or ${file:/path/to/secret}, secrets are redacted in logs
+type UpstreamConfig struct {
+	// Headers are set on every request to the upstream, e.g. {"Authorization": "Bearer ${env:RPC_TOKEN}"}
+	Headers map[string]string `json:"headers"`
+	// BasicAuthUser and BasicAuthPassword set the basic auth of every request to the upstream
+	BasicAuthUser     string `json:"basic_auth_user"`
+	BasicAuthPassword string `json:"basic_auth_password"`
+	// Path is appended to the path of the endpoint, e.g. "/${env:INFURA_KEY}"
+	Path string `json:"path"`
+	// Query is added to the query of the endpoint, e.g. {"apikey": "${file:/run/secrets/apikey}"}
+	Query map[string]string `json:"query"`
+}
+
+// resolveSecrets replaces the secret references in s with their values
+func resolveSecrets(s string) (string, error) {
+	var resolveErr error
+	resolved := secretRefRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		m := secretRefRegexp.FindStringSubmatch(ref)
+		var value string
+		switch m[1] {
+		case "env":
+			v, ok := os.LookupEnv(m[2])
+			if !ok {
+				resolveErr = fmt.Errorf("secret env %s is not set", m[2])
+			}
+			value = v
+		case "file":
+			data, err := ioutil.ReadFile(m[2])
+			if err != nil {
+				resolveErr = fmt.Errorf("fail to read secret file %s, err:%w", m[2], err)
+			}
+			value = strings.TrimSpace(string(data))
+		}
+		addSecret(value)
+		return value
+	})
+	return resolved, resolveErr
+}
+
+func addSecret(secret string) {
+	if len(secret) < minSecretLen {
+		return
+	}
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	knownSecrets[secret] = true
+}
+
+// redact replaces all the known secrets in s
+func redact(s string) string {
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+	for secret := range knownSecrets {
+		s = strings.ReplaceAll(s, secret, redactedSecret)
+	}
+	return s
+}
+
+// redactEndpoint learns the secrets embedded in the endpoint url, i.e. password, values of secretQueryKeys and
+// api keys in path, and returns the endpoint with them redacted
+func redactEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return redact(endpoint)
+	}
+	if password, ok := u.User.Password(); ok {
+		addSecret(password)
+	}
+	query := u.Query()
+	for k := range query {
+		for _, secretKey := range secretQueryKeys {
+			if strings.EqualFold(k, secretKey) {
+				for _, v := range query[k] {
+					addSecret(v)
+				}
+			}
+		}
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if len(segment) >= minPathKeyLen && letterRegexp.MatchString(segment) && digitRegexp.MatchString(segment) {
+			addSecret(segment)
+		}
+	}
+	return redact(endpoint)
+}
+
+// redactEndpointError returns err with the secrets of endpoint redacted, e.g. the url in the *url.Error of url.Parse
+func redactEndpointError(endpoint string, err error) error {
+	redacted := redactEndpoint(endpoint)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// the secrets of a url which can't be parsed are unknown
+		u := redactedSecret
+		if _, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+			u = redactEndpoint(urlErr.URL)
+		}
+		return &url.Error{Op: urlErr.Op, URL: u, Err: errors.New(redact(urlErr.Err.Error()))}
+	}
+	return errors.New(redact(strings.ReplaceAll(err.Error(), endpoint, redacted)))
+}
+
+// upstreamEndpoint returns the endpoint with the path and query of cfg appended
+func (c *UpstreamConfig) upstreamEndpoint(endpoint string) (string, error) {
+	if c.Path == "" && len(c.Query) == 0 {
+		return endpoint, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if c.Path != "" {
+		path, err := resolveSecrets(c.Path)
+		if err != nil {
+			return "", err
+		}
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + strings.TrimLeft(path, "/")
+	}
+	query := u.Query()
+	for k, v := range c.Query {
+		value, err := resolveSecrets(v)
+		if err != nil {
+			return "", err
+		}
+		query.Set(k, value)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// director returns the director which sets the headers and basic auth of cfg after the original one
+func (c *UpstreamConfig) director(original func(*http.Request)) (func(*http.Request), error) {
+	headers := make(map[string]string)
+	for k, v := range c.Headers {
+		value, err := resolveSecrets(v)
+		if err != nil {
+			return nil, err
+		}
+		headers[k] = value
+	}
+	user, err := resolveSecrets(c.BasicAuthUser)
+	if err != nil {
+		return nil, err
+	}
+	password, err := resolveSecrets(c.BasicAuthPassword)
+	if err != nil {
+		return nil, err
+	}
+	addSecret(password)
+	return func(req *http.Request) {
+		original(req)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		if user != "" || password != "" {
+			req.SetBasicAuth(user, password)
+		}
+	}, nil
+}
+
+// newConfiguredProxy creates the reverse proxy of chainId with the upstream settings of cfg
+func newConfiguredProxy(originEndpoint string, chainId uint64, cfg *ProxyConfig) (*httputil.ReverseProxy, error) {
+	// the config may not come from LoadProxyConfig
+	if err := cfg.RateLimit.validate(); err != nil {
+		return nil, err
+	}
+	endpoint := originEndpoint
+	if cfg.Upstream != nil {
+		var err error
+		if endpoint, err = cfg.Upstream.upstreamEndpoint(originEndpoint); err != nil {
+			return nil, redactEndpointError(originEndpoint, err)
+		}
+	}
+	p, err := newChainProxy(endpoint, chainId)
+	if err != nil {
+		return nil, redactEndpointError(endpoint, err)
+	}
+	if (chainId == harmonyChainId || chainId == harmonyTestnetChainId) && len(cfg.Harmony.shards()) > 0 {
+		if p.Director, err = cfg.Harmony.director(p.Director, cfg.Upstream); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Upstream != nil {
+		if p.Director, err = cfg.Upstream.director(p.Director); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RateLimit != nil && cfg.RateLimit.Upstream != nil {
+		p.Transport = &upstreamLimitTransport{limiter: newLimiter(cfg.RateLimit.Upstream), base: baseTransport(p)}
+	}
+	return p, nil
+}
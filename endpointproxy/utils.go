@@ -1,11 +1,15 @@
 package endpointproxy
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"sync"
 	"time"
 
 	"github.com/celer-network/goutils/log"
@@ -16,6 +20,28 @@ const (
 	MethodEthGetBlockByNumber = "eth_getBlockByNumber"
 	MethodEthCall             = "eth_call"
 
+	MethodEthBlockNumber           = "eth_blockNumber"
+	MethodEthGetBlockByHash        = "eth_getBlockByHash"
+	MethodEthGetTransactionByHash  = "eth_getTransactionByHash"
+	MethodEthGetTransactionReceipt = "eth_getTransactionReceipt"
+	MethodEthGetBlockReceipts      = "eth_getBlockReceipts"
+
+	MethodEthGetTransactionByBlockHashAndIndex   = "eth_getTransactionByBlockHashAndIndex"
+	MethodEthGetTransactionByBlockNumberAndIndex = "eth_getTransactionByBlockNumberAndIndex"
+
+	MethodEthEstimateGas      = "eth_estimateGas"
+	MethodEthCreateAccessList = "eth_createAccessList"
+	MethodEthGetBalance       = "eth_getBalance"
+	MethodEthGetStorageAt     = "eth_getStorageAt"
+
+	MethodEthGasPrice             = "eth_gasPrice"
+	MethodEthMaxPriorityFeePerGas = "eth_maxPriorityFeePerGas"
+	MethodEthFeeHistory           = "eth_feeHistory"
+
+	MethodEthGetFilterLogs    = "eth_getFilterLogs"
+	MethodEthGetFilterChanges = "eth_getFilterChanges"
+	MethodEthSubscription     = "eth_subscription"
+
 	shibuyaChainId = 81
 	astarChainId   = 592
 	shidenChainId  = 336
@@ -67,16 +93,23 @@ type jsonError struct {
 }
 
 type ServerWrap struct {
-	Svr      *http.Server
+	Svr *http.Server
+	// Port is 0 if the server doesn't listen on tcp
 	Port     int
+	Addr     string
 	Endpoint string
 }
 
-var chainIdSvrMap = make(map[uint64]ServerWrap)
+var (
+	chainIdSvrMap  = make(map[uint64]ServerWrap)
+	chainIdSvrLock sync.Mutex
+)
 
-func checkProxyStatus(chainId uint64, port int, originEndpoint string) bool {
+func checkProxyStatus(chainId uint64, addr string, originEndpoint string) bool {
+	chainIdSvrLock.Lock()
 	svrWrap, ok := chainIdSvrMap[chainId]
-	if ok && svrWrap.Port == port {
+	chainIdSvrLock.Unlock()
+	if ok && svrWrap.Addr == addr {
 		if svrWrap.Endpoint != originEndpoint {
 			// close old server
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -85,10 +118,10 @@ func checkProxyStatus(chainId uint64, port int, originEndpoint string) bool {
 				cancel()
 			}()
 			svrWrap.Svr.Shutdown(ctx)
-			log.Infof("close endpoint proxy success, chainid %d, port: %d, rpc: %s", chainId, svrWrap.Port, originEndpoint)
+			log.Infof("close endpoint proxy success, chainid %d, addr: %s, rpc: %s", chainId, svrWrap.Addr, redactEndpoint(originEndpoint))
 			return true
 		} else {
-			log.Infof("remain current proxy server, chainid %d, port: %d, rpc: %s", chainId, svrWrap.Port, originEndpoint)
+			log.Infof("remain current proxy server, chainid %d, addr: %s, rpc: %s", chainId, svrWrap.Addr, redactEndpoint(originEndpoint))
 			return false
 		}
 	} else {
@@ -96,81 +129,130 @@ func checkProxyStatus(chainId uint64, port int, originEndpoint string) bool {
 	}
 }
 
-// it will use chainId to determined which proxy to launch
+// it will use chainId to determined which proxy to launch, the proxy only listens on 127.0.0.1:{port}
 func StartProxy(originEndpoint string, chainId uint64, port int) error {
-	if checkProxyStatus(chainId, port, originEndpoint) {
+	return StartProxyWithConfig(originEndpoint, chainId, &ProxyConfig{Port: port})
+}
+
+// StartProxyWithConfig is same as StartProxy but the listen address and other settings come from cfg,
+// a nil cfg listens on a port of 127.0.0.1 chosen by the system
+func StartProxyWithConfig(originEndpoint string, chainId uint64, cfg *ProxyConfig) error {
+	if cfg == nil {
+		cfg = new(ProxyConfig)
+	}
+	addr := cfg.listenAddr()
+	if checkProxyStatus(chainId, addr, originEndpoint) {
 		smallDelay()
-		log.Infof("proxy for chain:%d, endpoint:%s, port:%d start...", chainId, originEndpoint, port)
+		log.Infof("proxy for chain:%d, endpoint:%s, addr:%s start...", chainId, redactEndpoint(originEndpoint), addr)
 	} else {
-		log.Infof("proxy for chain:%d, endpoint:%s, port:%d already start...", chainId, originEndpoint, port)
+		log.Infof("proxy for chain:%d, endpoint:%s, addr:%s already start...", chainId, redactEndpoint(originEndpoint), addr)
 		return nil
 	}
-	var err error
+	p, err := newConfiguredProxy(originEndpoint, chainId, cfg)
+	if err != nil {
+		log.Errorf("fail to start this proxy, err:%s", redact(err.Error()))
+		return err
+	}
+	handler, err := newProxyHandler(p, cfg, chainId)
+	if err != nil {
+		log.Errorf("fail to start this proxy, err:%s", err.Error())
+		return err
+	}
+	ln, err := cfg.listen()
+	if err != nil {
+		log.Errorf("fail to listen on %s, err:%s", addr, err.Error())
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	startCustomProxy(ln, addr, mux, chainId, originEndpoint)
+	smallDelay()
+	log.Infof("start proxy for chain:%d, endpoint:%s, addr:%s", chainId, redactEndpoint(originEndpoint), addr)
+	return nil
+}
+
+// newChainProxy creates the reverse proxy of chainId, requests are forwarded to the ipc path if originEndpoint is an ipc endpoint
+func newChainProxy(originEndpoint string, chainId uint64) (*httputil.ReverseProxy, error) {
+	path, isIpc := ipcPath(originEndpoint)
+	if !isIpc {
+		return newReverseProxyByChainId(originEndpoint, chainId)
+	}
+	p, err := newReverseProxyByChainId(ipcPlaceholderEndpoint, chainId)
+	if err != nil {
+		return nil, err
+	}
+	p.Transport = &ipcTransport{path: path}
+	return p, nil
+}
+
+// newReverseProxyByChainId uses chainId to determine which reverse proxy to create for originEndpoint
+func newReverseProxyByChainId(originEndpoint string, chainId uint64) (*httputil.ReverseProxy, error) {
 	switch chainId {
 	case zkSyncTestnetChainId, zkSyncMainnetChainId:
 		h := new(ZkSyncProxy)
-		err = h.startZkSyncProxy(originEndpoint, port, chainId)
+		return h.newZkSyncProxy(originEndpoint)
 	case godwokenTestnetChainId, godwokenMainnetChainId:
 		h := new(GodwokenProxy)
-		err = h.startGodwokenProxy(originEndpoint, port, chainId)
+		return h.newGodwokenProxy(originEndpoint)
 	case sxChainId, sxTestnetChainId:
 		h := new(SxProxy)
-		err = h.startSxProxy(originEndpoint, port, chainId)
+		return h.newSxProxy(originEndpoint)
 	case platonChainId:
 		h := new(PlatonProxy)
-		err = h.startPlatonProxy(originEndpoint, port, chainId)
+		return h.newPlatonProxy(originEndpoint)
 	case crabChainId:
 		h := new(CrabProxy)
-		err = h.startCrabProxy(originEndpoint, port, chainId)
+		return h.newCrabProxy(originEndpoint)
 	case ontologyChainId:
 		h := new(OntologyProxy)
-		err = h.startOntologyProxy(originEndpoint, port, chainId)
+		return h.newOntologyProxy(originEndpoint)
 	case confluxChainId:
 		h := new(ConfluxProxy)
-		err = h.startConfluxProxy(originEndpoint, port, chainId)
+		return h.newConfluxProxy(originEndpoint)
 	case astarChainId, shidenChainId, shibuyaChainId:
 		h := new(AstarProxy)
-		err = h.startAstarProxy(originEndpoint, port, chainId)
+		return h.newAstarProxy(originEndpoint)
 	case acalaTestnetChainId, acalaChainId:
 		h := new(AcalaProxy)
-		err = h.startAcalaProxy(originEndpoint, port, chainId)
+		return h.newAcalaProxy(originEndpoint)
 	case cloverChainId, cloverTestnetChainId:
 		h := new(CloverProxy)
-		err = h.startCloverProxy(originEndpoint, port, chainId)
+		return h.newCloverProxy(originEndpoint)
 	case harmonyChainId, harmonyTestnetChainId:
 		h := new(HarmonyProxy)
-		err = h.startHarmonyProxy(originEndpoint, port, chainId)
+		return h.newHarmonyProxy(originEndpoint)
 	case celoChainId, celoTestnetChainId:
 		c := new(CeloProxy)
-		err = c.startCeloProxy(originEndpoint, port, chainId)
+		return c.newCeloProxy(originEndpoint)
 	default:
-		return fmt.Errorf("do not support proxy for this chain, origin endpoint:%s, chainId:%d", originEndpoint, chainId)
-	}
-	if err != nil {
-		log.Errorf("fail to start this proxy, err:%s", err.Error())
-		return err
+		return nil, fmt.Errorf("do not support proxy for this chain, origin endpoint:%s, chainId:%d", redactEndpoint(originEndpoint), chainId)
 	}
-	smallDelay()
-	log.Infof("start proxy for chain:%d, endpoint:%s, port:%d", chainId, originEndpoint, port)
-	return nil
 }
 
-func startCustomProxyByPort(port int, handler http.Handler, chainId uint64, endpoint string) {
-	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: handler}
+func startCustomProxy(ln net.Listener, addr string, handler http.Handler, chainId uint64, endpoint string) {
+	server := &http.Server{Handler: handler}
+	var port int
+	if tcpAddr, ok := ln.Addr().(*net.TCPAddr); ok {
+		port = tcpAddr.Port
+	}
+	chainIdSvrLock.Lock()
 	chainIdSvrMap[chainId] = ServerWrap{
 		Svr:      server,
 		Port:     port,
+		Addr:     addr,
 		Endpoint: endpoint,
 	}
-	err := server.ListenAndServe()
-	//err := http.ListenAndServe(fmt.Sprintf(":%d", port), handler)
-	if err != nil {
-		if err == http.ErrServerClosed {
-			log.Warnf("endpoint proxy close, port %d", port)
-		} else {
-			log.Fatal(err)
+	chainIdSvrLock.Unlock()
+	go func() {
+		err := server.Serve(ln)
+		if err != nil {
+			if err == http.ErrServerClosed {
+				log.Warnf("endpoint proxy close, addr %s", addr)
+			} else {
+				log.Fatal(err)
+			}
 		}
-	}
+	}()
 }
 
 // ProxyRequestHandler handles the http request using proxy
@@ -183,3 +265,31 @@ func proxyRequestHandler(proxy *httputil.ReverseProxy) func(http.ResponseWriter,
 func smallDelay() {
 	time.Sleep(100 * time.Millisecond)
 }
+
+// readReqBody reads the whole request body and puts it back, so that the request can still be forwarded
+func readReqBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// parseJsonRpcBody parses a single or batch json rpc request
+func parseJsonRpcBody(body []byte) (msgs []*jsonrpcMessage, batch bool, err error) {
+	body = bytes.TrimLeft(body, " \t\r\n")
+	if len(body) > 0 && body[0] == '[' {
+		err = json.Unmarshal(body, &msgs)
+		return msgs, true, err
+	}
+	msg := new(jsonrpcMessage)
+	if err = json.Unmarshal(body, msg); err != nil {
+		return nil, false, err
+	}
+	return []*jsonrpcMessage{msg}, false, nil
+}
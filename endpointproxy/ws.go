@@ -0,0 +1,295 @@
+package endpointproxy
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+
+	// the messages of the clients are capped like the request bodies
+	wsMaxClientMessageBytes = defaultMaxBodyBytes
+	// the max number of calls of a websocket waiting for the responses to rewrite
+	wsMaxPendingCalls = 1024
+)
+
+var (
+	errWsMessageTooLarge = errors.New("websocket message too large")
+	errWsReservedBits    = errors.New("websocket frame with reserved bits")
+)
+
+// isWebsocketUpgrade tells if the request opens a websocket, which the reverse proxy forwards as a raw connection
+func isWebsocketUpgrade(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade")
+}
+
+// wsCallRequest returns the plain http request for the upstream calls made while serving the websocket of req
+func wsCallRequest(req *http.Request) *http.Request {
+	callReq := req.Clone(req.Context())
+	callReq.Method = http.MethodPost
+	for _, header := range []string{"Upgrade", "Connection", "Sec-WebSocket-Key", "Sec-WebSocket-Version",
+		"Sec-WebSocket-Protocol", "Sec-WebSocket-Extensions"} {
+		callReq.Header.Del(header)
+	}
+	callReq.Header.Set("Content-Type", "application/json")
+	return callReq
+}
+
+// wsMessageHook rewrites a data message of a websocket, it returns nil to drop the message, and can send
+// messages back with reply
+type wsMessageHook func(msg []byte, reply func(msg []byte)) []byte
+
+// interceptWebsocket makes the data messages of the websocket opened by req go through the hooks, the hooks may be nil.
+// The compression extensions are removed from req so that the messages can be read
+func interceptWebsocket(w http.ResponseWriter, req *http.Request, fromClient, toClient wsMessageHook) http.ResponseWriter {
+	req.Header.Del("Sec-WebSocket-Extensions")
+	return &wsHijacker{ResponseWriter: w, fromClient: fromClient, toClient: toClient}
+}
+
+// wsHijacker wraps the connection hijacked by the reverse proxy on the websocket upgrade
+type wsHijacker struct {
+	http.ResponseWriter
+	fromClient, toClient wsMessageHook
+}
+
+func (h *wsHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijack is not supported")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	c := &wsConn{
+		Conn:       conn,
+		fromClient: h.fromClient,
+		toClient:   h.toClient,
+		in:         &wsStream{maxMessage: wsMaxClientMessageBytes, mask: true},
+		out:        &wsStream{},
+	}
+	return c, brw, nil
+}
+
+// wsConn is the client side of a websocket, reads are the frames from the client and writes are the frames to it
+type wsConn struct {
+	net.Conn
+	fromClient, toClient wsMessageHook
+	in, out              *wsStream
+
+	// writeLock keeps the frames written to the client whole
+	writeLock sync.Mutex
+	readBuf   []byte
+	pending   []byte
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	if c.fromClient == nil {
+		return c.Conn.Read(p)
+	}
+	if c.readBuf == nil {
+		c.readBuf = make([]byte, 32*1024)
+	}
+	for len(c.pending) == 0 {
+		n, err := c.Conn.Read(c.readBuf)
+		if n > 0 {
+			out, ferr := c.in.feed(c.readBuf[:n], c.fromClient, c.reply)
+			if ferr != nil {
+				return 0, ferr
+			}
+			c.pending = out
+		}
+		if err != nil && len(c.pending) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if c.toClient == nil {
+		return c.write(p)
+	}
+	out, err := c.out.feed(p, c.toClient, c.reply)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = c.write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.Conn.Write(p)
+}
+
+// reply sends a text message to the client
+func (c *wsConn) reply(msg []byte) {
+	c.write(encodeWsFrame(wsOpText, msg, false))
+}
+
+// wsStream reassembles the data messages of one direction of a websocket, other frames are passed as they are
+type wsStream struct {
+	// maxMessage caps the size of the frames and messages, 0 is no limit
+	maxMessage int
+	// mask is true for the frames from the client, which are masked
+	mask bool
+
+	buf []byte
+	// msg is the fragmented data message being reassembled
+	msg        []byte
+	msgOpcode  byte
+	inMsg      bool
+	compressed bool
+}
+
+// feed parses the frames in data, and returns the bytes to forward with the data messages rewritten by hook
+func (s *wsStream) feed(data []byte, hook wsMessageHook, reply func([]byte)) ([]byte, error) {
+	s.buf = append(s.buf, data...)
+	var out []byte
+	for {
+		n, fin, rsv, opcode, payload, err := parseWsFrame(s.buf, s.maxMessage)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		if rsv != 0 && s.mask {
+			// no extension is negotiated, the messages of the client must not skip the hook
+			return nil, errWsReservedBits
+		}
+		raw := s.buf[:n]
+		isData := opcode == wsOpText || opcode == wsOpBinary
+		switch {
+		case isData && fin && rsv == 0:
+			out = s.emit(out, opcode, hook(payload, reply))
+		case isData:
+			// a compressed message can't be read, it is passed as it is
+			s.msgOpcode, s.inMsg, s.compressed, s.msg = opcode, !fin, rsv != 0, append([]byte{}, payload...)
+			if s.compressed {
+				out = append(out, raw...)
+			}
+		case opcode == wsOpContinuation && s.inMsg && !s.compressed:
+			if s.maxMessage > 0 && len(s.msg)+len(payload) > s.maxMessage {
+				return nil, errWsMessageTooLarge
+			}
+			s.msg = append(s.msg, payload...)
+			if fin {
+				out = s.emit(out, s.msgOpcode, hook(s.msg, reply))
+				s.inMsg, s.msg = false, nil
+			}
+		default:
+			if opcode == wsOpContinuation && fin {
+				s.inMsg = false
+			}
+			out = append(out, raw...)
+		}
+		s.buf = s.buf[n:]
+	}
+	// keep the partial frame without the consumed bytes
+	s.buf = append([]byte{}, s.buf...)
+	return out, nil
+}
+
+func (s *wsStream) emit(out []byte, opcode byte, msg []byte) []byte {
+	if msg == nil {
+		return out
+	}
+	return append(out, encodeWsFrame(opcode, msg, s.mask)...)
+}
+
+// parseWsFrame parses the frame at the start of buf, n is 0 if the frame is not complete yet, the payload is unmasked
+func parseWsFrame(buf []byte, maxPayload int) (n int, fin bool, rsv, opcode byte, payload []byte, err error) {
+	if len(buf) < 2 {
+		return 0, false, 0, 0, nil, nil
+	}
+	fin = buf[0]&0x80 != 0
+	rsv = buf[0] & 0x70
+	opcode = buf[0] & 0x0f
+	masked := buf[1]&0x80 != 0
+	length := uint64(buf[1] & 0x7f)
+	header := 2
+	switch length {
+	case 126:
+		if len(buf) < 4 {
+			return 0, false, 0, 0, nil, nil
+		}
+		length = uint64(binary.BigEndian.Uint16(buf[2:4]))
+		header = 4
+	case 127:
+		if len(buf) < 10 {
+			return 0, false, 0, 0, nil, nil
+		}
+		length = binary.BigEndian.Uint64(buf[2:10])
+		header = 10
+	}
+	if (maxPayload > 0 && length > uint64(maxPayload)) || length > 1<<40 {
+		return 0, false, 0, 0, nil, errWsMessageTooLarge
+	}
+	var key []byte
+	if masked {
+		if len(buf) < header+4 {
+			return 0, false, 0, 0, nil, nil
+		}
+		key = buf[header : header+4]
+		header += 4
+	}
+	if uint64(len(buf)-header) < length {
+		return 0, false, 0, 0, nil, nil
+	}
+	n = header + int(length)
+	payload = buf[header:n]
+	if masked {
+		unmasked := make([]byte, len(payload))
+		for i := range payload {
+			unmasked[i] = payload[i] ^ key[i%4]
+		}
+		payload = unmasked
+	}
+	return n, fin, rsv, opcode, payload, nil
+}
+
+// encodeWsFrame encodes a final frame, the frames to the server are masked
+func encodeWsFrame(opcode byte, payload []byte, mask bool) []byte {
+	frame := []byte{0x80 | opcode, 0}
+	switch {
+	case len(payload) < 126:
+		frame[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		frame[1] = 126
+		frame = append(frame, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame[1] = 127
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	if !mask {
+		return append(frame, payload...)
+	}
+	frame[1] |= 0x80
+	key := make([]byte, 4)
+	rand.Read(key)
+	frame = append(frame, key...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i%4])
+	}
+	return frame
+}
@@ -0,0 +1,132 @@
+package endpointproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsReader reads the final text frames of a websocket
+type wsReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (r *wsReader) next() ([]byte, error) {
+	chunk := make([]byte, 4096)
+	for {
+		n, fin, _, opcode, payload, err := parseWsFrame(r.buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			if !fin || opcode != wsOpText {
+				return nil, fmt.Errorf("unexpected frame, fin:%v, opcode:%d", fin, opcode)
+			}
+			r.buf = r.buf[n:]
+			return payload, nil
+		}
+		if n, err = r.r.Read(chunk); err != nil {
+			return nil, err
+		}
+		r.buf = append(r.buf, chunk[:n]...)
+	}
+}
+
+// wsUpstream answers the first message of the websocket with resp, then sends notification
+func wsUpstream(t *testing.T, wantCall, resp, notification string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isWebsocketUpgrade(req) || req.Header.Get("Sec-WebSocket-Extensions") != "" {
+			t.Errorf("invalid upgrade request %v", req.Header)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		if call, err := (&wsReader{r: brw.Reader}).next(); err != nil || string(call) != wantCall {
+			t.Errorf("upstream got %s, want %s, err:%v", call, wantCall, err)
+		}
+		conn.Write(encodeWsFrame(wsOpText, []byte(resp), false))
+		// a fragmented message
+		frame := encodeWsFrame(wsOpText, []byte(notification[:10]), false)
+		frame[0] &^= 0x80
+		conn.Write(frame)
+		conn.Write(encodeWsFrame(wsOpContinuation, []byte(notification[10:]), false))
+		io.Copy(io.Discard, conn)
+	}))
+}
+
+func TestNormalizeWebsocket(t *testing.T) {
+	call := `{"jsonrpc":"2.0","id":1,"method":"eth_getTransactionReceipt","params":["0x01"]}`
+	upstream := wsUpstream(t, call,
+		`{"jsonrpc":"2.0","id":1,"result":{"logs":[],"logsBloom":"0x00","cumulativeGasUsed":"0x1","gasUsed":"0x1","status":"0x1"}}`,
+		`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0x9","result":{"topics":[],"logIndex":"0x1"}}}`)
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	policy := &NormalizePolicy{Receipts: &ReceiptPolicy{Fill: true}, Logs: &LogPolicy{Fill: true}}
+	proxy := httptest.NewServer(normalizeHandler(policy, 1, httputil.NewSingleHostReverseProxy(target)))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: proxy\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Extensions: permessage-deflate\r\n\r\n"))
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want 101", resp.StatusCode)
+	}
+	conn.Write(encodeWsFrame(wsOpText, []byte(call), true))
+	messages := &wsReader{r: r}
+
+	var receipt struct {
+		ID     int        `json:"id"`
+		Result jsonObject `json:"result"`
+	}
+	msg, err := messages.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(msg, &receipt); err != nil {
+		t.Fatal(err)
+	}
+	if receipt.ID != 1 || receipt.Result.getString("type") != "0x0" {
+		t.Errorf("receipt is not normalized, id:%d, type:%s", receipt.ID, receipt.Result.getString("type"))
+	}
+	var notification struct {
+		Params struct {
+			Result jsonObject `json:"result"`
+		} `json:"params"`
+	}
+	if msg, err = messages.next(); err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(msg, &notification); err != nil {
+		t.Fatal(err)
+	}
+	if string(notification.Params.Result["removed"]) != "false" {
+		t.Errorf("log notification is not normalized: %v", notification.Params.Result)
+	}
+}